@@ -0,0 +1,240 @@
+package workspace
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// ConfigFile is the name of the workspace configuration file, in the
+// directory of the root package.
+const ConfigFile = "gx-workspace.json"
+
+// Config holds the settings of a workspace. It is read from gx-workspace.json
+// in the directory of the root package, and every field is optional.
+type Config struct {
+	// CommitTemplate and PullRequestTemplate are text/template sources for
+	// commit messages and pull requests. The ...File variants name a file
+	// relative to the workspace holding the template instead.
+	CommitTemplate          string `json:",omitempty"`
+	CommitTemplateFile      string `json:",omitempty"`
+	PullRequestTemplate     string `json:",omitempty"`
+	PullRequestTemplateFile string `json:",omitempty"`
+
+	// Check is a shell command run in place of 'go get -d -t ./...' and
+	// 'gx test ./...' to test packages without a check command of their
+	// own.
+	Check string `json:",omitempty"`
+
+	// Hooks maps hook names to shell commands run for every package.
+	Hooks map[string][]string `json:",omitempty"`
+
+	// TestRetries is the number of times failing tests are run again before
+	// giving up.
+	TestRetries int `json:",omitempty"`
+
+	// GitBackend selects how clones, pulls, checkouts and pushes are done:
+	// "git" runs the git binary, and "go" uses a git implementation in Go.
+	// The default is "git". Other operations, like preflight checks,
+	// committing and gx release, run the git binary with both.
+	GitBackend string `json:",omitempty"`
+
+	// URLRewrites maps dvcsimport prefixes to the URL prefixes they are
+	// cloned from, like git's insteadOf. The longest matching prefix wins.
+	URLRewrites map[string]string `json:",omitempty"`
+
+	// Remotes names the git remotes of repositories. OrgRemotes overrides
+	// its fields for repositories whose dvcsimport starts with the key, like
+	// "github.com/libp2p/". The longest matching prefix wins.
+	Remotes    RemoteConfig
+	OrgRemotes map[string]RemoteConfig `json:",omitempty"`
+
+	// Offline makes updates clone and pull repositories only from their
+	// mirrors in ~/.gx/mirrors, as synced by 'gx-workspace mirror sync'.
+	Offline bool `json:",omitempty"`
+
+	// Sign signs every commit and tag created during an update, including
+	// those of 'gx release', and makes push verify the signatures first.
+	// SigningKey is passed as git's user.signingkey, and SigningFormat as
+	// gpg.format: openpgp, x509 or ssh. Both default to git's settings.
+	Sign          bool   `json:",omitempty"`
+	SigningKey    string `json:",omitempty"`
+	SigningFormat string `json:",omitempty"`
+
+	// Packages holds settings for single packages, by package name.
+	Packages map[string]PackageConfig `json:",omitempty"`
+
+	dir string
+}
+
+// RemoteConfig names the remotes of a repository.
+type RemoteConfig struct {
+	// Upstream is the remote which is cloned, pulled from and targeted by
+	// pull requests, "origin" by default.
+	Upstream string `json:",omitempty"`
+	// Fork is the remote update branches are pushed to. If it doesn't
+	// exist, it is created with 'hub fork'. If empty, branches are pushed
+	// to Upstream, or else to the only other remote.
+	Fork string `json:",omitempty"`
+}
+
+// PackageConfig holds the settings of a single package.
+type PackageConfig struct {
+	// Check is a shell command run in place of the default tests.
+	Check string `json:",omitempty"`
+
+	// Hooks maps hook names to shell commands run for this package, after
+	// those of the workspace.
+	Hooks map[string][]string `json:",omitempty"`
+
+	// TestRetries overrides the workspace's TestRetries for this package.
+	TestRetries int `json:",omitempty"`
+
+	// FlakyTests names tests known to be flaky, which are skipped.
+	FlakyTests []string `json:",omitempty"`
+
+	// Branch is the default branch of the package's repository, which is
+	// pulled, branched from and targeted by pull requests. It is detected
+	// from the upstream remote if empty.
+	Branch string `json:",omitempty"`
+}
+
+func (ws *Workspace) loadConfig() (*Config, error) {
+	conf := Config{dir: ws.Dir}
+
+	data, err := ioutil.ReadFile(filepath.Join(ws.Dir, ConfigFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &conf, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %s", ConfigFile, err)
+	}
+
+	if err := checkHooks(conf.Hooks); err != nil {
+		return nil, fmt.Errorf("error in %s: %s", ConfigFile, err)
+	}
+	for name, pc := range conf.Packages {
+		if err := checkHooks(pc.Hooks); err != nil {
+			return nil, fmt.Errorf("error in %s, package %s: %s", ConfigFile, name, err)
+		}
+	}
+	if _, ok := conf.URLRewrites[""]; ok {
+		return nil, fmt.Errorf("error in %s: empty URL rewrite prefix", ConfigFile)
+	}
+	if _, ok := conf.OrgRemotes[""]; ok {
+		return nil, fmt.Errorf("error in %s: empty OrgRemotes prefix", ConfigFile)
+	}
+	if err := checkSigningFormat(conf.SigningFormat); err != nil {
+		return nil, fmt.Errorf("error in %s: %s", ConfigFile, err)
+	}
+	return &conf, nil
+}
+
+// template returns the inline template, or the contents of the template
+// file, or def if neither is set.
+func (conf *Config) template(inline string, file string, def string) (string, error) {
+	if inline != "" {
+		return inline, nil
+	}
+	if file != "" {
+		data, err := ioutil.ReadFile(filepath.Join(conf.dir, file))
+		if err != nil {
+			return "", fmt.Errorf("error reading template: %s", err)
+		}
+		return string(data), nil
+	}
+	return def, nil
+}
+
+// checkCmd returns the check command for pkg: from the package's entry in the
+// workspace config, from the "check" field of the package's gx metadata, or
+// the workspace default, in that order. An empty string means the default
+// tests.
+func (conf *Config) checkCmd(pkg *gx.Package) string {
+	if pc, ok := conf.Packages[pkg.Name]; ok && pc.Check != "" {
+		return pc.Check
+	}
+	if check, ok := gxMeta(pkg)["check"].(string); ok && check != "" {
+		return check
+	}
+	return conf.Check
+}
+
+// testRetries returns the number of retries for failing tests of the named
+// package.
+func (conf *Config) testRetries(name string) int {
+	if pc, ok := conf.Packages[name]; ok && pc.TestRetries != 0 {
+		return pc.TestRetries
+	}
+	return conf.TestRetries
+}
+
+// flakyTestsPattern returns a regexp for 'go test -skip' matching the known
+// flaky tests of the named package, or an empty string if there are none.
+func (conf *Config) flakyTestsPattern(name string) string {
+	tests := conf.Packages[name].FlakyTests
+	if len(tests) == 0 {
+		return ""
+	}
+
+	quoted := make([]string, len(tests))
+	for i, t := range tests {
+		quoted[i] = regexp.QuoteMeta(t)
+	}
+	return "^(" + strings.Join(quoted, "|") + ")$"
+}
+
+// cloneURL returns the URL to clone the repository at dvcsimport from. If no
+// rewrite matches, github.com repositories are cloned over SSH and all others
+// over HTTPS.
+func (conf *Config) cloneURL(dvcsimport string) string {
+	var prefix string
+	for p := range conf.URLRewrites {
+		if strings.HasPrefix(dvcsimport, p) && len(p) > len(prefix) {
+			prefix = p
+		}
+	}
+	if prefix != "" {
+		return conf.URLRewrites[prefix] + strings.TrimPrefix(dvcsimport, prefix)
+	}
+
+	if strings.HasPrefix(dvcsimport, "github.com/") {
+		return "git@github.com:" + strings.TrimPrefix(dvcsimport, "github.com/")
+	}
+	return "https://" + dvcsimport
+}
+
+// remotes returns the remotes of the repository with the given dvcsimport.
+func (conf *Config) remotes(dvcsimport string) RemoteConfig {
+	rc := conf.Remotes
+
+	var prefix string
+	for p := range conf.OrgRemotes {
+		if strings.HasPrefix(dvcsimport, p) && len(p) > len(prefix) {
+			prefix = p
+		}
+	}
+	if org, ok := conf.OrgRemotes[prefix]; ok && prefix != "" {
+		if org.Upstream != "" {
+			rc.Upstream = org.Upstream
+		}
+		if org.Fork != "" {
+			rc.Fork = org.Fork
+		}
+	}
+
+	if rc.Upstream == "" {
+		rc.Upstream = "origin"
+	}
+	return rc
+}
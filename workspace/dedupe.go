@@ -0,0 +1,157 @@
+package workspace
+
+import (
+	"fmt"
+	"sort"
+
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// Duplicates returns the packages in the dependency tree of pkg which are
+// imported at more than one hash, with their hashes.
+func (ws *Workspace) Duplicates(pkg *gx.Package) (map[string][]string, error) {
+	deps, err := ws.PM.EnumerateDependencies(pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	byname := make(map[string][]string)
+	for hash, name := range deps {
+		byname[name] = append(byname[name], hash)
+	}
+
+	dupes := make(map[string][]string)
+	for name, hashes := range byname {
+		if len(hashes) > 1 {
+			sort.Strings(hashes)
+			dupes[name] = hashes
+		}
+	}
+	return dupes, nil
+}
+
+// duplicateNames returns the sorted names of dupes.
+func duplicateNames(dupes map[string][]string) []string {
+	var names []string
+	for name := range dupes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Dedupe resolves the duplicate dependencies of the root package within the
+// update in progress. See queueDedupe.
+func (ws *Workspace) Dedupe(ui *UpdateInfo) ([]string, error) {
+	if ui.Current != "" || len(ui.Level) > 0 {
+		return nil, fmt.Errorf("finish the current step before deduplicating")
+	}
+
+	if err := ws.UseGoPath(ui); err != nil {
+		return nil, err
+	}
+
+	root, err := ws.RootPackage()
+	if err != nil {
+		return nil, err
+	}
+
+	dupes, err := ws.Duplicates(root)
+	if err != nil {
+		return nil, err
+	}
+	if len(dupes) == 0 {
+		return nil, nil
+	}
+
+	return ws.queueDedupe(ui, root, duplicateNames(dupes))
+}
+
+// queueDedupe resolves the named duplicate dependencies by updating every
+// package depending on them to a single version. This is the version already
+// published during the update, or else the latest published version from the
+// package's lastpubver. The dependents are put back on the todo list, even if
+// they were done already, so the new version bubbles up the tree. It returns
+// the names of the duplicates it resolved.
+func (ws *Workspace) queueDedupe(ui *UpdateInfo, root *gx.Package, names []string) ([]string, error) {
+	intodo := make(map[string]bool)
+	for _, name := range ui.Todo {
+		intodo[name] = true
+	}
+
+	var resolved []string
+	for _, name := range names {
+		if intodo[name] {
+			// The new version will be published during the update.
+			continue
+		}
+
+		if _, ok := ui.Changes[name]; !ok {
+			skip, err := ws.syncRepo(StartOptions{}, *root, ui, name)
+			if err != nil {
+				return nil, fmt.Errorf("error syncing %s: %s", name, err)
+			}
+			if skip {
+				continue
+			}
+		}
+		ws.printf("> Deduplicating %s at %s\n", name, ui.Changes[name])
+		resolved = append(resolved, name)
+	}
+	if len(resolved) == 0 {
+		return nil, nil
+	}
+
+	requeue, err := ws.TodoList(root, resolved)
+	if err != nil {
+		return nil, err
+	}
+	queued := make(map[string]bool)
+	for _, name := range requeue {
+		queued[name] = true
+	}
+	for _, name := range ui.Todo {
+		queued[name] = true
+	}
+
+	remove := func(names []string) []string {
+		var out []string
+		for _, name := range names {
+			if !queued[name] {
+				out = append(out, name)
+			}
+		}
+		return out
+	}
+	ui.Done = remove(ui.Done)
+	ui.Skipped = remove(ui.Skipped)
+
+	for _, name := range resolved {
+		var isroot bool
+		for _, r := range ui.Roots {
+			if r == name {
+				isroot = true
+				break
+			}
+		}
+		if !isroot {
+			ui.Roots = append(ui.Roots, name)
+		}
+	}
+
+	// Recompute the order of the whole tree, so that the requeued packages
+	// come before the packages depending on them.
+	all, err := ws.TodoList(root, ui.Roots)
+	if err != nil {
+		return nil, err
+	}
+	var todo []string
+	for _, name := range all {
+		if queued[name] {
+			todo = append(todo, name)
+		}
+	}
+	ui.Todo = todo
+
+	return resolved, nil
+}
@@ -0,0 +1,81 @@
+package workspace
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+func TestDedupeRequeue(t *testing.T) {
+	tt := newTestTree(t)
+
+	// The new version of c depends on a newer d than a and b do, so
+	// updating c leaves them with two versions of d.
+	d1 := testPackage("d", "0.1.0")
+	d2 := testPackage("d", "0.2.0")
+	c2 := testPackage("c", "0.2.0", &gx.Dependency{Name: "d", Hash: "QmD2", Version: "0.2.0"})
+	a := testPackage("a", "0.1.0",
+		&gx.Dependency{Name: "c", Hash: "QmC1", Version: "0.1.0"},
+		&gx.Dependency{Name: "d", Hash: "QmD1", Version: "0.1.0"})
+	b := testPackage("b", "0.1.0",
+		&gx.Dependency{Name: "c", Hash: "QmC1", Version: "0.1.0"},
+		&gx.Dependency{Name: "d", Hash: "QmD1", Version: "0.1.0"})
+	for hash, pkg := range map[string]*gx.Package{"QmD1": d1, "QmD2": d2, "QmC2": c2, "QmA1": a, "QmB1": b} {
+		writePackage(t, filepath.Join(tt.src, "gx", "ipfs", hash, pkg.Name), pkg)
+	}
+	for hash, pkg := range map[string]*gx.Package{"QmD2": d2, "QmC2": c2, "QmA1": a, "QmB1": b} {
+		dir := tt.dir(pkg.Name)
+		writePackage(t, dir, pkg)
+		writeLastPubVer(t, dir, pkg.Version, hash)
+		if err := tt.commit(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ui := tt.start(t)
+	opts := NextOptions{NoTest: true}
+	for i := 0; i < 2; i++ {
+		if err := tt.ws.Next(ui, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ui.Published["a"] == "" {
+		t.Fatalf("a wasn't published: %+v", ui)
+	}
+
+	// Deduplicating d in b puts the published a back on the todo list.
+	opts.Dedupe = true
+	if err := tt.ws.Next(ui, opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(ui.Todo) == 0 || ui.Todo[0] != "a" {
+		t.Fatalf("a wasn't requeued, todo is %v", ui.Todo)
+	}
+	for !ui.Finished() {
+		if err := tt.ws.Next(ui, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []DepUpdate{
+		{Name: "c", OldVersion: "0.1.0", NewVersion: "0.2.0", OldHash: "QmC1", NewHash: "QmC2"},
+		{Name: "d", OldVersion: "0.1.0", NewVersion: "0.2.0", OldHash: "QmD1", NewHash: "QmD2"},
+	}
+	for _, name := range []string{"a", "b"} {
+		var done bool
+		for _, n := range ui.Done {
+			done = done || n == name
+		}
+		if !done {
+			t.Errorf("%s isn't done: done %v, skipped %v", name, ui.Done, ui.Skipped)
+		}
+		if got := ui.Updates[name]; !reflect.DeepEqual(got, want) {
+			t.Errorf("updates of %s are %+v, want %+v", name, got, want)
+		}
+	}
+	if ui.Changes["a"] != "QmA2" || ui.Changes["b"] != "QmB2" {
+		t.Errorf("a and b weren't published: changes %v", ui.Changes)
+	}
+}
@@ -0,0 +1,96 @@
+package workspace
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+)
+
+// Kinds of findings of the checks run on updated packages.
+const (
+	// FindingDuplicate is a dependency required in more than one version,
+	// as reported by 'gx deps dupes'.
+	FindingDuplicate = "duplicate"
+	// FindingMissing is an import not provided by a gx dependency, as
+	// reported by 'gx-go dvcs-deps'.
+	FindingMissing = "missing"
+)
+
+// Finding is a problem found by the checks of an updated package.
+type Finding struct {
+	Kind string
+	// Dep is the dependency or import path concerned, and Detail the rest
+	// of the line reported by the check.
+	Dep    string
+	Detail string `json:",omitempty"`
+}
+
+func (f Finding) String() string {
+	s := f.Kind + " " + f.Dep
+	if f.Detail != "" {
+		s += " " + f.Detail
+	}
+	return s
+}
+
+// parseFindings turns every non-empty line of the output of a check into a
+// finding.
+func parseFindings(kind string, out []byte) []Finding {
+	var findings []Finding
+	for _, l := range bytes.Split(out, []byte("\n")) {
+		// gx deps dupes prints "package <name> imported as both <a> and <b>".
+		line := strings.TrimPrefix(string(l), "package ")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		findings = append(findings, Finding{
+			Kind:   kind,
+			Dep:    strings.TrimSuffix(fields[0], ":"),
+			Detail: strings.Join(fields[1:], " "),
+		})
+	}
+	return findings
+}
+
+// recordFindings replaces the findings of the named package in the session
+// state.
+func (ui *UpdateInfo) recordFindings(name string, findings []Finding) {
+	if len(findings) == 0 {
+		delete(ui.Findings, name)
+		return
+	}
+	if ui.Findings == nil {
+		ui.Findings = map[string][]Finding{}
+	}
+	ui.Findings[name] = findings
+}
+
+// printSummary prints the findings and test failures of all packages, once
+// the update is finished.
+func (ws *Workspace) printSummary(ui *UpdateInfo) {
+	var names []string
+	for name := range ui.Findings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ws.printf("!! %s: %d problems found by checks\n", name, len(ui.Findings[name]))
+		for _, f := range ui.Findings[name] {
+			ws.printf("!!   %s\n", f)
+		}
+	}
+
+	names = names[:0]
+	for name := range ui.TestFailures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ws.printf("!! %s: tests failed: %s\n", name, ui.TestFailures[name])
+	}
+
+	if len(ui.Findings) == 0 && len(ui.TestFailures) == 0 {
+		ws.printf("> No problems found by checks.\n")
+	}
+}
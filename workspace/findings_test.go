@@ -0,0 +1,112 @@
+package workspace
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFindings(t *testing.T) {
+	cases := []struct {
+		kind string
+		out  string
+		want []Finding
+	}{
+		{
+			kind: FindingDuplicate,
+			out:  "package go-log imported as both QmLog1 and QmLog2\n\npackage go-cid imported as both QmCid1 and QmCid2\n",
+			want: []Finding{
+				{Kind: FindingDuplicate, Dep: "go-log", Detail: "imported as both QmLog1 and QmLog2"},
+				{Kind: FindingDuplicate, Dep: "go-cid", Detail: "imported as both QmCid1 and QmCid2"},
+			},
+		},
+		{
+			kind: FindingMissing,
+			out:  "github.com/test/d\n  github.com/test/e: vendored\n",
+			want: []Finding{
+				{Kind: FindingMissing, Dep: "github.com/test/d"},
+				{Kind: FindingMissing, Dep: "github.com/test/e", Detail: "vendored"},
+			},
+		},
+		{
+			kind: FindingMissing,
+			out:  "\n",
+		},
+	}
+
+	for _, c := range cases {
+		got := parseFindings(c.kind, []byte(c.out))
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("findings of %q are %+v, want %+v", c.out, got, c.want)
+		}
+	}
+}
+
+func TestPrintSummary(t *testing.T) {
+	buf := new(bytes.Buffer)
+	ws := &Workspace{Out: buf}
+
+	ws.printSummary(&UpdateInfo{})
+	if got := buf.String(); got != "> No problems found by checks.\n" {
+		t.Errorf("summary without problems is %q", got)
+	}
+
+	buf.Reset()
+	ws.printSummary(&UpdateInfo{
+		Findings: map[string][]Finding{
+			"b": {{Kind: FindingMissing, Dep: "github.com/test/d"}},
+			"a": {
+				{Kind: FindingDuplicate, Dep: "c", Detail: "imported as both QmC1 and QmC2"},
+				{Kind: FindingMissing, Dep: "github.com/test/d"},
+			},
+		},
+		TestFailures: map[string]string{"app": "exit status 1"},
+	})
+	want := strings.Join([]string{
+		"!! a: 2 problems found by checks",
+		"!!   duplicate c imported as both QmC1 and QmC2",
+		"!!   missing github.com/test/d",
+		"!! b: 1 problems found by checks",
+		"!!   missing github.com/test/d",
+		"!! app: tests failed: exit status 1",
+		"",
+	}, "\n")
+	if got := buf.String(); got != want {
+		t.Errorf("summary is\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestStrict(t *testing.T) {
+	tt := newTestTree(t)
+	ui := tt.start(t)
+	opts := NextOptions{NoTest: true, Strict: true}
+	tt.runner.Results["gx-go dvcs-deps"] = FakeResult{Output: "github.com/test/d\n"}
+
+	err := tt.ws.Next(ui, opts)
+	if err == nil || !strings.Contains(err.Error(), "checks found 1 problems") {
+		t.Fatalf("expected the checks of a to fail, got %v", err)
+	}
+	if ui.Current != "" || len(ui.Todo) == 0 || ui.Todo[0] != "a" {
+		t.Fatalf("a isn't on the todo list anymore: current %q, todo %v", ui.Current, ui.Todo)
+	}
+	want := []Finding{{Kind: FindingMissing, Dep: "github.com/test/d"}}
+	if !reflect.DeepEqual(ui.Findings["a"], want) {
+		t.Errorf("findings of a are %+v, want %+v", ui.Findings["a"], want)
+	}
+
+	// Once the missing dependency is fixed, a passes the checks and is
+	// published with its update.
+	delete(tt.runner.Results, "gx-go dvcs-deps")
+	for i := 0; i < 2; i++ {
+		if err := tt.ws.Next(ui, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := ui.Findings["a"]; ok {
+		t.Errorf("findings of a weren't cleared: %+v", ui.Findings["a"])
+	}
+	if ui.Changes["a"] != "QmA2" {
+		t.Errorf("change of a is %q, want QmA2", ui.Changes["a"])
+	}
+}
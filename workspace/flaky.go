@@ -0,0 +1,54 @@
+package workspace
+
+import (
+	"fmt"
+	"io"
+)
+
+// TestError is returned when the tests of a package fail.
+type TestError struct {
+	Package string
+	Err     error
+}
+
+func (e *TestError) Error() string {
+	return e.Err.Error()
+}
+
+// runTestsRetrying runs the tests like runTests, and runs them again up to
+// retries times if they fail.
+func (ws *Workspace) runTestsRetrying(w io.Writer, dir string, checkcmd string, skip string, retries int) error {
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			fmt.Fprintf(w, "> Tests failed: %s, retrying (%d of %d)\n", err, attempt, retries)
+		}
+		err = ws.runTests(w, dir, checkcmd, skip)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// recordTestResult records a test failure of the named package in the
+// session state, or clears it if the package passed. With tolerate set, a
+// test failure is not returned, so the update continues.
+func (ui *UpdateInfo) recordTestResult(name string, err error, tolerate bool) error {
+	terr, ok := err.(*TestError)
+	if !ok {
+		if err == nil {
+			delete(ui.TestFailures, name)
+		}
+		return err
+	}
+
+	if ui.TestFailures == nil {
+		ui.TestFailures = map[string]string{}
+	}
+	ui.TestFailures[name] = terr.Error()
+	if tolerate {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,227 @@
+package workspace
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Push pushes the branches of all changed packages and opens a pull request
+// for each of them. Pull requests which were already opened are skipped, so
+// Push can be run again after a failure.
+func (ws *Workspace) Push(ui *UpdateInfo) error {
+	if ui.Current != "" || len(ui.Level) > 0 || len(ui.Todo) > 1 {
+		return fmt.Errorf("update not yet finished")
+	}
+
+	if err := ws.UseGoPath(ui); err != nil {
+		return err
+	}
+
+	pkg, err := ws.RootPackage()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range ui.Done {
+		dir, err := ws.PkgDirByName(*pkg, name)
+		if err != nil {
+			return err
+		}
+
+		log, err := ws.openLog(ui, name, "push")
+		if err != nil {
+			return err
+		}
+		err = ws.runHook(log, HookPrePush, name, dir)
+		ui.recordHookResult(name, err)
+		if err != nil {
+			log.Close()
+			if werr := ws.WriteProgress(ui); werr != nil {
+				return werr
+			}
+			return fmt.Errorf("%s (log: %s)", err, log.Path)
+		}
+		if ws.Config.Sign {
+			base := ws.remotesAt(dir).Upstream + "/" + ws.defaultBranch(log, dir, name)
+			if err := ws.verifySignatures(log, dir, base, ui.Branch); err != nil {
+				log.Close()
+				return fmt.Errorf("refusing to push %s: %s (log: %s)", name, err, log.Path)
+			}
+		}
+		remote, err := ws.pushBranch(log, dir, ui.Branch, ui.PushRemotes[name])
+		log.Close()
+		if err != nil {
+			return fmt.Errorf("error pushing %s: %s (log: %s)", name, err, log.Path)
+		}
+		ws.printf("> Pushed %s to %s\n", name, remote)
+
+		if ui.PushRemotes == nil {
+			ui.PushRemotes = map[string]string{}
+		}
+		ui.PushRemotes[name] = remote
+		if err := ws.WriteProgress(ui); err != nil {
+			return err
+		}
+	}
+
+	if ui.PullRequests == nil {
+		ui.PullRequests = map[string]string{}
+	}
+
+	var pr string
+	var prs []string
+	for _, name := range ui.Done {
+		if existing, ok := ui.PullRequests[name]; ok {
+			ws.printf("> Pull request for %s already exists: %s\n", name, existing)
+			pr = existing
+			prs = append(prs, pr)
+			continue
+		}
+
+		dir, err := ws.PkgDirByName(*pkg, name)
+		if err != nil {
+			return err
+		}
+
+		msg, err := pullRequestMessage(ws.Config, &MessageData{
+			Package:      name,
+			Roots:        ui.Roots,
+			Updates:      ui.Updates[name],
+			PullRequests: prs,
+		})
+		if err != nil {
+			return fmt.Errorf("error rendering pull request message: %s", err)
+		}
+
+		log, err := ws.openLog(ui, name, "push")
+		if err != nil {
+			return err
+		}
+		pr, err = ws.pullRequest(log, dir, ws.defaultBranch(log, dir, name), msg)
+		log.Close()
+		if err != nil {
+			return fmt.Errorf("%s (log: %s)", err, log.Path)
+		}
+		ws.printf("> Opened pull request for %s: %s\n", name, pr)
+		prs = append(prs, pr)
+
+		ui.PullRequests[name] = pr
+		if err := ws.WriteProgress(ui); err != nil {
+			return err
+		}
+	}
+
+	if ui.Worktrees {
+		if err := ws.RemoveWorktrees(ui); err != nil {
+			return fmt.Errorf("error removing worktrees: %s", err)
+		}
+	}
+
+	ws.printf("> Finished: %s\n", pr)
+	return nil
+}
+
+// pushBranch pushes branch of the repository at dir and returns the remote
+// it was pushed to. That is the configured fork, created with 'hub fork' if
+// missing, or else the previous remote of the package, or else upstream. If
+// pushing upstream fails, the only other remote is used, or a new fork.
+func (ws *Workspace) pushBranch(w io.Writer, dir string, branch string, previous string) (string, error) {
+	rc := ws.remotesAt(dir)
+	remotes, err := ws.gitRemotes(dir)
+	if err != nil {
+		return "", err
+	}
+
+	remote := rc.Fork
+	switch {
+	case remote != "":
+		if !containsString(remotes, remote) {
+			if err := ws.hubFork(w, dir, remote); err != nil {
+				return "", err
+			}
+		}
+	case previous != "":
+		remote = previous
+	default:
+		if err := ws.gitPush(w, rc.Upstream, branch, dir); err == nil {
+			return rc.Upstream, nil
+		}
+
+		others := forkCandidates(remotes, rc.Upstream)
+		if len(others) == 0 {
+			if err := ws.hubFork(w, dir, ""); err != nil {
+				return "", err
+			}
+			remotes, err = ws.gitRemotes(dir)
+			if err != nil {
+				return "", err
+			}
+			others = forkCandidates(remotes, rc.Upstream)
+		}
+		if len(others) != 1 {
+			return "", fmt.Errorf("error running git push: can't choose a remote among %s, set Remotes.Fork in %s", strings.Join(others, ", "), ConfigFile)
+		}
+		remote = others[0]
+	}
+
+	if err := ws.gitPush(w, remote, branch, dir); err != nil {
+		return "", fmt.Errorf("error running git push: %s", err)
+	}
+	return remote, nil
+}
+
+// forkCandidates returns the remotes which could be the user's fork: all but
+// upstream and the mirror.
+func forkCandidates(remotes []string, upstream string) []string {
+	var out []string
+	for _, r := range remotes {
+		if r != upstream && r != "mirror" {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// hubFork forks the repository at dir on GitHub and adds the fork as a
+// remote, named after the user if name is empty.
+func (ws *Workspace) hubFork(w io.Writer, dir string, name string) error {
+	args := []string{"fork"}
+	if name != "" {
+		args = append(args, "--remote-name", name)
+	}
+	fmt.Fprintf(w, "> Running 'hub %s' in %s\n", strings.Join(args, " "), dir)
+	forkcmd := ws.command("hub", args...)
+	forkcmd.Dir = dir
+	forkcmd.Stdout = w
+	forkcmd.Stderr = w
+
+	if err := forkcmd.Run(); err != nil {
+		return fmt.Errorf("error running hub fork: %s", err)
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// pullRequest opens a pull request against base for the current branch of
+// the repository at dir, and returns its URL.
+func (ws *Workspace) pullRequest(w io.Writer, dir string, base string, msg string) (string, error) {
+	fmt.Fprintf(w, "> Running 'hub pull-request -b %s' in %s\n", base, dir)
+	prcmd := ws.command("hub", "pull-request", "-b", base, "-m", msg)
+	// prcmd := ws.command("echo", "https://github.com/libp2p/"+name+"/pull/123")
+	prcmd.Dir = dir
+	prcmd.Stderr = w
+	out, err := prcmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("error running hub pull-request: %s", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,116 @@
+package workspace
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// finishUpdate runs all steps of the update of tt.
+func finishUpdate(t *testing.T, tt *testTree) *UpdateInfo {
+	t.Helper()
+	ui := tt.start(t)
+	for !ui.Finished() {
+		if err := tt.ws.Next(ui, NextOptions{NoTest: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ui
+}
+
+func TestPush(t *testing.T) {
+	cases := []struct {
+		name string
+		// script changes the results of the runner before pushing.
+		script func(tt *testTree, ui *UpdateInfo)
+		// remote is the remote all branches should have been pushed to, or
+		// empty if pushing should fail.
+		remote string
+		err    string
+	}{
+		{
+			name:   "upstream",
+			script: func(tt *testTree, ui *UpdateInfo) {},
+			remote: "origin",
+		},
+		{
+			name: "fork fallback",
+			script: func(tt *testTree, ui *UpdateInfo) {
+				tt.runner.Results["git push --set-upstream origin "+ui.Branch] = FakeResult{Err: fmt.Errorf("exit status 128")}
+				tt.runner.Results["hub fork"] = FakeResult{Effect: func(c *Cmd) error {
+					tt.runner.Results["git remote"] = FakeResult{Output: "origin\nme\n"}
+					return nil
+				}}
+			},
+			remote: "me",
+		},
+		{
+			name: "push failure",
+			script: func(tt *testTree, ui *UpdateInfo) {
+				tt.runner.Results["git push *"] = FakeResult{Err: fmt.Errorf("exit status 128")}
+				tt.runner.Results["hub fork"] = FakeResult{Err: fmt.Errorf("exit status 1")}
+			},
+			err: "error pushing a: error running hub fork",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tt := newTestTree(t)
+			ui := finishUpdate(t, tt)
+			c.script(tt, ui)
+
+			err := tt.ws.Push(ui)
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("expected error %q, got %v", c.err, err)
+				}
+				if len(ui.PushRemotes) != 0 || len(ui.PullRequests) != 0 {
+					t.Errorf("recorded pushes %v and pull requests %v after failing", ui.PushRemotes, ui.PullRequests)
+				}
+				for _, call := range tt.runner.Calls() {
+					if call.Name == "hub" && len(call.Args) > 0 && call.Args[0] == "pull-request" {
+						t.Errorf("opened a pull request after failing to push")
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, name := range ui.Done {
+				dir := tt.dirOf(t, name)
+				if ui.PushRemotes[name] != c.remote {
+					t.Errorf("%s pushed to %q, want %q", name, ui.PushRemotes[name], c.remote)
+				}
+				if !tt.ran(dir, "git push --set-upstream "+c.remote+" "+ui.Branch) {
+					t.Errorf("%s wasn't pushed to %s:\n%s", name, c.remote, tt.runner.Transcript())
+				}
+				if !tt.ran(dir, "hub pull-request -b master") {
+					t.Errorf("no pull request opened for %s:\n%s", name, tt.runner.Transcript())
+				}
+				if ui.PullRequests[name] == "" {
+					t.Errorf("pull request of %s wasn't recorded", name)
+				}
+			}
+		})
+	}
+}
+
+func TestPushUnfinished(t *testing.T) {
+	tt := newTestTree(t)
+	ui := tt.start(t)
+	if err := tt.ws.Next(ui, NextOptions{NoTest: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tt.ws.Push(ui); err == nil {
+		t.Fatal("pushed an unfinished update")
+	}
+	for _, call := range tt.runner.Calls() {
+		if call.Name == "git" && len(call.Args) > 0 && call.Args[0] == "push" {
+			t.Errorf("ran %s for an unfinished update", call.String())
+		}
+	}
+}
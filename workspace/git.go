@@ -0,0 +1,145 @@
+package workspace
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// hasChangesSincePublish reports whether HEAD of the repository at dir has
+// commits which weren't part of the last 'gx release'.
+func (ws *Workspace) hasChangesSincePublish(dir string) (bool, error) {
+	commits, err := ws.unpublishedCommits(dir, "HEAD")
+	if err != nil {
+		return false, err
+	}
+	return len(commits) > 0, nil
+}
+
+// unpublishedCommits returns the commits of rev after the last 'gx release',
+// which is the last commit changing .gx/lastpubver, as "<hash> <subject>"
+// lines, newest first. It fails if the package was never released.
+func (ws *Workspace) unpublishedCommits(dir string, rev string) ([]string, error) {
+	last, err := ws.gitOutput(dir, "log", "-1", "--format=%H", rev, "--", ".gx/lastpubver")
+	if err != nil {
+		return nil, fmt.Errorf("error finding the last release: %s", err)
+	}
+	if last == "" {
+		return nil, fmt.Errorf("no release found in the history of %s", rev)
+	}
+
+	out, err := ws.gitOutput(dir, "log", "--format=%h %s", last+".."+rev)
+	if err != nil {
+		return nil, fmt.Errorf("error listing commits: %s", err)
+	}
+	if out == "" {
+		return nil, nil
+	}
+	return strings.Split(out, "\n"), nil
+}
+
+func (ws *Workspace) gitClone(w io.Writer, dvcsimport string, dir string) error {
+	pdir := filepath.Dir(dir)
+	err := os.MkdirAll(pdir, 0775)
+	if err != nil {
+		return err
+	}
+
+	mirrored, err := ws.cloneFromMirror(w, dvcsimport, dir)
+	if err != nil {
+		return fmt.Errorf("error cloning from mirror: %s", err)
+	}
+	if mirrored {
+		return ws.renameOrigin(w, dvcsimport, dir)
+	}
+	if ws.Config.Offline {
+		return fmt.Errorf("can't clone %s offline, there is no mirror of it (run `gx-workspace mirror sync`)", dvcsimport)
+	}
+
+	if err := ws.vcs().Clone(w, ws.Config.cloneURL(dvcsimport), dir); err != nil {
+		return fmt.Errorf("error during git clone: %s", err)
+	}
+	return ws.renameOrigin(w, dvcsimport, dir)
+}
+
+// renameOrigin renames origin of a fresh clone to the configured upstream
+// remote.
+func (ws *Workspace) renameOrigin(w io.Writer, dvcsimport string, dir string) error {
+	upstream := ws.Config.remotes(dvcsimport).Upstream
+	if upstream == "origin" {
+		return nil
+	}
+	if err := ws.runGit(w, dir, "remote", "rename", "origin", upstream); err != nil {
+		return fmt.Errorf("error renaming origin to %s: %s", upstream, err)
+	}
+	return nil
+}
+
+// remotesAt returns the remotes of the repository of the package at dir.
+func (ws *Workspace) remotesAt(dir string) RemoteConfig {
+	var pkg gx.Package
+	if err := gx.LoadPackageFile(&pkg, filepath.Join(dir, gx.PkgFileName)); err != nil {
+		return ws.Config.remotes("")
+	}
+	return ws.Config.remotes(pkgDvcsImport(&pkg))
+}
+
+// defaultBranch returns the default branch of the repository of the named
+// package at dir. It is taken from the package's config, or else from the
+// upstream remote, and is master if that doesn't work.
+func (ws *Workspace) defaultBranch(w io.Writer, dir string, name string) string {
+	if b := ws.Config.Packages[name].Branch; b != "" {
+		return b
+	}
+
+	b, err := ws.vcs().DefaultBranch(dir, ws.remotesAt(dir).Upstream)
+	if err != nil {
+		fmt.Fprintf(w, "WARNING: can't detect the default branch of %s, assuming master: %s\n", name, err)
+		return "master"
+	}
+	return b
+}
+
+func (ws *Workspace) gitPull(w io.Writer, dir string, branch string) error {
+	remote, err := ws.pullRemote(dir)
+	if err != nil {
+		return err
+	}
+	if err := ws.vcs().Pull(w, dir, remote, branch); err != nil {
+		return fmt.Errorf("error during git pull: %s", err)
+	}
+	return nil
+}
+
+// gitCheckout checks out branch, creating or resetting it at base, or at
+// HEAD if base is empty.
+func (ws *Workspace) gitCheckout(w io.Writer, dir string, branch string, base string) error {
+	if err := ws.vcs().Checkout(w, dir, branch, base); err != nil {
+		return fmt.Errorf("error during git checkout: %s", err)
+	}
+	return nil
+}
+
+func (ws *Workspace) checkBranch(dir string) (string, error) {
+	b, err := ws.vcs().CurrentBranch(dir)
+	if err != nil {
+		return "", fmt.Errorf("error checking branch: %s", err)
+	}
+	return b, nil
+}
+
+func (ws *Workspace) gitRemotes(dir string) ([]string, error) {
+	remotes, err := ws.vcs().Remotes(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error running git remote: %s", err)
+	}
+	return remotes, nil
+}
+
+func (ws *Workspace) gitPush(w io.Writer, remote string, branch string, dir string) error {
+	return ws.vcs().Push(w, dir, remote, branch)
+}
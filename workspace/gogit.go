@@ -0,0 +1,222 @@
+package workspace
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	billy "github.com/go-git/go-billy/v5"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage"
+)
+
+// GoGit is a VCS implemented in Go, which doesn't need the git binary.
+type GoGit struct {
+	// Storage, if set, returns the object storage and the worktree to use
+	// for the repository at dir, instead of the files there. This allows
+	// working on in-memory repositories.
+	Storage func(dir string) (storage.Storer, billy.Filesystem, error)
+}
+
+func (g *GoGit) open(dir string) (*git.Repository, error) {
+	if g.Storage != nil {
+		st, fs, err := g.Storage(dir)
+		if err != nil {
+			return nil, err
+		}
+		return git.Open(st, fs)
+	}
+	return git.PlainOpen(dir)
+}
+
+func (g *GoGit) Clone(w io.Writer, url string, dir string) error {
+	fmt.Fprintf(w, "> Cloning %s into %s\n", url, dir)
+	opts := &git.CloneOptions{URL: url, Progress: w}
+
+	var err error
+	if g.Storage != nil {
+		var st storage.Storer
+		var fs billy.Filesystem
+		st, fs, err = g.Storage(dir)
+		if err == nil {
+			_, err = git.Clone(st, fs, opts)
+		}
+	} else {
+		_, err = git.PlainClone(dir, false, opts)
+	}
+	if err != nil {
+		return &VCSError{Op: "clone", Dir: dir, Err: err}
+	}
+	return nil
+}
+
+func (g *GoGit) Pull(w io.Writer, dir string, remote string, branch string) error {
+	fmt.Fprintf(w, "> Pulling %s from %s in %s\n", branch, remote, dir)
+	repo, err := g.open(dir)
+	if err != nil {
+		return &VCSError{Op: "pull", Dir: dir, Err: err}
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return &VCSError{Op: "pull", Dir: dir, Err: err}
+	}
+
+	err = wt.Pull(&git.PullOptions{
+		RemoteName:    remote,
+		ReferenceName: plumbing.NewBranchReferenceName(branch),
+		Progress:      w,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return &VCSError{Op: "pull", Dir: dir, Err: err}
+	}
+	return nil
+}
+
+// Checkout checks out branch like 'git checkout -B': the branch is created
+// or reset at base, and HEAD points to it. Local changes are kept when base
+// is HEAD. go-git can't carry them over to another commit, so switching to a
+// different base is refused unless the worktree is clean.
+func (g *GoGit) Checkout(w io.Writer, dir string, branch string, base string) error {
+	fmt.Fprintf(w, "> Checking out %s in %s\n", branch, dir)
+	repo, err := g.open(dir)
+	if err != nil {
+		return &VCSError{Op: "checkout", Dir: dir, Err: err}
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return &VCSError{Op: "checkout", Dir: dir, Err: err}
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return &VCSError{Op: "checkout", Dir: dir, Err: err}
+	}
+	target := head.Hash()
+	if base != "" {
+		hash, err := repo.ResolveRevision(plumbing.Revision(base))
+		if err != nil {
+			return &VCSError{Op: "checkout", Dir: dir, Err: err}
+		}
+		target = *hash
+	}
+
+	if target != head.Hash() {
+		status, err := wt.Status()
+		if err != nil {
+			return &VCSError{Op: "checkout", Dir: dir, Err: err}
+		}
+		if !status.IsClean() {
+			return &VCSError{Op: "checkout", Dir: dir, Err: fmt.Errorf("local changes would be lost by moving %s to %s", branch, base)}
+		}
+	}
+
+	// HEAD is moved to the branch at the current commit first, which
+	// leaves the worktree and index alone, and then reset to the target.
+	name := plumbing.NewBranchReferenceName(branch)
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(name, head.Hash())); err != nil {
+		return &VCSError{Op: "checkout", Dir: dir, Err: err}
+	}
+	if err := repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, name)); err != nil {
+		return &VCSError{Op: "checkout", Dir: dir, Err: err}
+	}
+	if target == head.Hash() {
+		return nil
+	}
+
+	if err := wt.Reset(&git.ResetOptions{Commit: target, Mode: git.MergeReset}); err != nil {
+		return &VCSError{Op: "checkout", Dir: dir, Err: err}
+	}
+	return nil
+}
+
+func (g *GoGit) CurrentBranch(dir string) (string, error) {
+	repo, err := g.open(dir)
+	if err != nil {
+		return "", &VCSError{Op: "rev-parse", Dir: dir, Err: err}
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return "", &VCSError{Op: "rev-parse", Dir: dir, Err: err}
+	}
+	if !head.Name().IsBranch() {
+		return "HEAD", nil
+	}
+	return head.Name().Short(), nil
+}
+
+func (g *GoGit) DefaultBranch(dir string, remote string) (string, error) {
+	repo, err := g.open(dir)
+	if err != nil {
+		return "", &VCSError{Op: "symbolic-ref", Dir: dir, Err: err}
+	}
+
+	prefix := "refs/remotes/" + remote + "/"
+	ref, err := repo.Reference(plumbing.ReferenceName(prefix+"HEAD"), false)
+	if err == nil && ref.Type() == plumbing.SymbolicReference {
+		return strings.TrimPrefix(ref.Target().String(), prefix), nil
+	}
+
+	r, err := repo.Remote(remote)
+	if err != nil {
+		return "", &VCSError{Op: "ls-remote", Dir: dir, Err: err}
+	}
+	refs, err := r.List(&git.ListOptions{})
+	if err != nil {
+		return "", &VCSError{Op: "ls-remote", Dir: dir, Err: err}
+	}
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference {
+			return ref.Target().Short(), nil
+		}
+	}
+	return "", &VCSError{Op: "ls-remote", Dir: dir, Err: fmt.Errorf("HEAD branch of %s unknown", remote)}
+}
+
+func (g *GoGit) Remotes(dir string) ([]string, error) {
+	repo, err := g.open(dir)
+	if err != nil {
+		return nil, &VCSError{Op: "remote", Dir: dir, Err: err}
+	}
+	remotes, err := repo.Remotes()
+	if err != nil {
+		return nil, &VCSError{Op: "remote", Dir: dir, Err: err}
+	}
+
+	var names []string
+	for _, r := range remotes {
+		names = append(names, r.Config().Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (g *GoGit) Push(w io.Writer, dir string, remote string, branch string) error {
+	fmt.Fprintf(w, "> Pushing %s to %s in %s\n", branch, remote, dir)
+	repo, err := g.open(dir)
+	if err != nil {
+		return &VCSError{Op: "push", Dir: dir, Err: err}
+	}
+
+	name := plumbing.NewBranchReferenceName(branch)
+	err = repo.Push(&git.PushOptions{
+		RemoteName: remote,
+		RefSpecs:   []config.RefSpec{config.RefSpec(name + ":" + name)},
+		Progress:   w,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return &VCSError{Op: "push", Dir: dir, Err: err}
+	}
+
+	cfg, err := repo.Config()
+	if err != nil {
+		return &VCSError{Op: "push", Dir: dir, Err: err}
+	}
+	cfg.Branches[branch] = &config.Branch{Name: branch, Remote: remote, Merge: name}
+	if err := repo.SetConfig(cfg); err != nil {
+		return &VCSError{Op: "push", Dir: dir, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package workspace
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	billy "github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// memRepo is an in-memory repository on master with two commits changing
+// package.json, first and second.
+type memRepo struct {
+	g      *GoGit
+	repo   *git.Repository
+	fs     billy.Filesystem
+	first  plumbing.Hash
+	second plumbing.Hash
+}
+
+func newMemRepo(t *testing.T) *memRepo {
+	t.Helper()
+	st := memory.NewStorage()
+	fs := memfs.New()
+	repo, err := git.Init(st, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &memRepo{
+		g: &GoGit{Storage: func(dir string) (storage.Storer, billy.Filesystem, error) {
+			return st, fs, nil
+		}},
+		repo: repo,
+		fs:   fs,
+	}
+	r.first = r.commit(t, "first")
+	r.second = r.commit(t, "second")
+	return r
+}
+
+func (r *memRepo) write(t *testing.T, content string) {
+	t.Helper()
+	f, err := r.fs.Create("package.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (r *memRepo) read(t *testing.T) string {
+	t.Helper()
+	f, err := r.fs.Open("package.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func (r *memRepo) commit(t *testing.T, content string) plumbing.Hash {
+	t.Helper()
+	r.write(t, content)
+	wt, err := r.repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wt.Add("package.json"); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := wt.Commit(content, &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func (r *memRepo) ref(t *testing.T, name plumbing.ReferenceName) plumbing.Hash {
+	t.Helper()
+	ref, err := r.repo.Reference(name, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref.Hash()
+}
+
+func TestGoGitCheckout(t *testing.T) {
+	cases := []struct {
+		name string
+		// prepare runs before the checkout, and returns the base to check
+		// out.
+		prepare func(t *testing.T, r *memRepo) string
+		// commit is the commit the branch must point to, and content the
+		// contents of package.json afterwards.
+		commit  func(r *memRepo) plumbing.Hash
+		content string
+		err     string
+	}{
+		{
+			name: "head keeps local changes",
+			prepare: func(t *testing.T, r *memRepo) string {
+				r.write(t, "changed")
+				return ""
+			},
+			commit:  func(r *memRepo) plumbing.Hash { return r.second },
+			content: "changed",
+		},
+		{
+			name: "base moves the worktree",
+			prepare: func(t *testing.T, r *memRepo) string {
+				return r.first.String()
+			},
+			commit:  func(r *memRepo) plumbing.Hash { return r.first },
+			content: "first",
+		},
+		{
+			name: "existing branch is reset",
+			prepare: func(t *testing.T, r *memRepo) string {
+				name := plumbing.NewBranchReferenceName("gx/update-test")
+				if err := r.repo.Storer.SetReference(plumbing.NewHashReference(name, r.first)); err != nil {
+					t.Fatal(err)
+				}
+				return "master"
+			},
+			commit:  func(r *memRepo) plumbing.Hash { return r.second },
+			content: "second",
+		},
+		{
+			name: "base with local changes",
+			prepare: func(t *testing.T, r *memRepo) string {
+				r.write(t, "changed")
+				return r.first.String()
+			},
+			content: "changed",
+			err:     "local changes would be lost",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := newMemRepo(t)
+			base := c.prepare(t, r)
+
+			err := r.g.Checkout(ioutil.Discard, "repo", "gx/update-test", base)
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("expected error %q, got %v", c.err, err)
+				}
+				if b, _ := r.g.CurrentBranch("repo"); b != "master" {
+					t.Errorf("checked out %s after failing", b)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if b, _ := r.g.CurrentBranch("repo"); b != "gx/update-test" {
+					t.Errorf("current branch is %s, want gx/update-test", b)
+				}
+				if got := r.ref(t, plumbing.NewBranchReferenceName("gx/update-test")); got != c.commit(r) {
+					t.Errorf("branch points to %s, want %s", got, c.commit(r))
+				}
+			}
+
+			if got := r.ref(t, plumbing.NewBranchReferenceName("master")); got != r.second {
+				t.Errorf("master moved to %s", got)
+			}
+			if got := r.read(t); got != c.content {
+				t.Errorf("package.json is %q, want %q", got, c.content)
+			}
+		})
+	}
+}
+
+func TestGoGitRemotes(t *testing.T) {
+	r := newMemRepo(t)
+	for _, name := range []string{"upstream", "fork"} {
+		if _, err := r.repo.CreateRemote(&config.RemoteConfig{Name: name, URLs: []string{"https://example.com/" + name}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	remotes, err := r.g.Remotes("repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(remotes, " "); got != "fork upstream" {
+		t.Errorf("remotes are %q, want %q", got, "fork upstream")
+	}
+}
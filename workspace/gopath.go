@@ -0,0 +1,131 @@
+package workspace
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+const tempGoPathPrefix = "update-"
+
+// tempGoPathSessionFile is written into every temporary GOPATH and holds the
+// absolute path of the update progress file of the session that created it.
+const tempGoPathSessionFile = ".gx-workspace-session"
+
+// tempGoPathRoot returns the directory holding temporary GOPATHs.
+func tempGoPathRoot() (string, error) {
+	return homedir.Expand(filepath.Join("~", ".gx"))
+}
+
+func (ws *Workspace) createTempGoPath(gopath string, seed string) error {
+	if seed != "" {
+		seed, err := homedir.Expand(seed)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(seed); err != nil {
+			return fmt.Errorf("error reading seed GOPATH: %s", err)
+		}
+
+		err = os.MkdirAll(filepath.Dir(gopath), 0755)
+		if err != nil {
+			return err
+		}
+
+		ws.printf("> Seeding GOPATH=%s from %s\n", gopath, seed)
+		cpcmd := ws.command("cp", "-R", seed, gopath)
+		cpcmd.Stdout = ws.Out
+		cpcmd.Stderr = ws.Out
+		if err := cpcmd.Run(); err != nil {
+			return fmt.Errorf("error copying seed GOPATH: %s", err)
+		}
+
+		// The copy inherits the permissions of a possibly read-only cache.
+		err = filepath.Walk(gopath, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if fi.Mode()&os.ModeSymlink != 0 {
+				return nil
+			}
+			return os.Chmod(p, fi.Mode().Perm()|0200)
+		})
+		if err != nil {
+			return err
+		}
+
+		// Don't let the new GOPATH claim the session of the seed.
+		os.Remove(filepath.Join(gopath, tempGoPathSessionFile))
+	}
+
+	err := os.MkdirAll(gopath, 0755)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(gopath, tempGoPathSessionFile), []byte(ws.ProgressFile()+"\n"), 0644)
+}
+
+// TempGoPath is a temporary GOPATH created by 'update start --temp-gopath'.
+type TempGoPath struct {
+	Dir string
+	// Session is the progress file of the update which created the GOPATH,
+	// or empty if unknown.
+	Session string
+	// InUse is true if that update is still in progress in this GOPATH.
+	InUse bool
+	// SessionErr is set if the progress file of the session exists, but
+	// can't be read. The GOPATH may still be in use, and must be kept.
+	SessionErr error
+}
+
+// TempGoPaths lists all temporary GOPATHs.
+func TempGoPaths() ([]TempGoPath, error) {
+	root, err := tempGoPathRoot()
+	if err != nil {
+		return nil, err
+	}
+	return tempGoPathsIn(root)
+}
+
+func tempGoPathsIn(root string) ([]TempGoPath, error) {
+	dirs, err := filepath.Glob(filepath.Join(root, tempGoPathPrefix+"*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var out []TempGoPath
+	for _, dir := range dirs {
+		tgp := TempGoPath{Dir: dir}
+		if data, err := ioutil.ReadFile(filepath.Join(dir, tempGoPathSessionFile)); err == nil {
+			tgp.Session = strings.TrimSpace(string(data))
+		}
+		if tgp.Session != "" {
+			ui, err := ReadProgressFile(tgp.Session)
+			switch {
+			case err == nil:
+				tgp.InUse = filepath.Clean(ui.GoPath) == filepath.Clean(dir)
+			case !os.IsNotExist(err):
+				tgp.SessionErr = err
+			}
+		}
+		out = append(out, tgp)
+	}
+	return out, nil
+}
+
+// RemoveTempGoPath deletes a temporary GOPATH.
+func RemoveTempGoPath(dir string) error {
+	// go and gx leave read-only files around, which RemoveAll can't delete.
+	_ = filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err == nil && fi.IsDir() {
+			os.Chmod(p, fi.Mode().Perm()|0700)
+		}
+		return nil
+	})
+	return os.RemoveAll(dir)
+}
@@ -0,0 +1,111 @@
+package workspace
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTempGoPaths(t *testing.T) {
+	cases := []struct {
+		name string
+		// progress writes the progress file of the session, given the
+		// GOPATH.
+		progress func(t *testing.T, p string, gopath string)
+		inUse    bool
+		err      bool
+	}{
+		{
+			name: "in use",
+			progress: func(t *testing.T, p string, gopath string) {
+				writeFile(t, p, `{"GoPath": "`+gopath+`"}`)
+			},
+			inUse: true,
+		},
+		{
+			name: "other gopath",
+			progress: func(t *testing.T, p string, gopath string) {
+				writeFile(t, p, `{"GoPath": "/elsewhere"}`)
+			},
+		},
+		{
+			name:     "finished",
+			progress: func(t *testing.T, p string, gopath string) {},
+		},
+		{
+			name: "partly written",
+			progress: func(t *testing.T, p string, gopath string) {
+				writeFile(t, p, `{"GoPath": "`)
+			},
+			err: true,
+		},
+		{
+			name: "unreadable",
+			progress: func(t *testing.T, p string, gopath string) {
+				if err := os.Mkdir(p, 0755); err != nil {
+					t.Fatal(err)
+				}
+			},
+			err: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			root := t.TempDir()
+			gopath := filepath.Join(root, tempGoPathPrefix+"test")
+			progress := filepath.Join(root, ProgressFile)
+			writeFile(t, filepath.Join(gopath, tempGoPathSessionFile), progress+"\n")
+			c.progress(t, progress, gopath)
+
+			gopaths, err := tempGoPathsIn(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(gopaths) != 1 {
+				t.Fatalf("found %d GOPATHs, want 1", len(gopaths))
+			}
+			gp := gopaths[0]
+			if gp.Session != progress {
+				t.Errorf("session is %q, want %q", gp.Session, progress)
+			}
+			if gp.InUse != c.inUse {
+				t.Errorf("in use is %v, want %v", gp.InUse, c.inUse)
+			}
+			if (gp.SessionErr != nil) != c.err {
+				t.Errorf("session error is %v", gp.SessionErr)
+			}
+		})
+	}
+}
+
+func TestWriteProgressReplaces(t *testing.T) {
+	ws := &Workspace{Dir: t.TempDir()}
+	for _, gopath := range []string{"/a/long/gopath", "/b"} {
+		if err := ws.WriteProgress(&UpdateInfo{GoPath: gopath}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ui, err := ws.ReadProgress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ui.GoPath != "/b" {
+		t.Errorf("GOPATH is %q, want /b", ui.GoPath)
+	}
+	if _, err := os.Stat(ws.ProgressFile() + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+}
+
+func writeFile(t *testing.T, p string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,327 @@
+package workspace
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// TodoList returns the packages in the dependency tree of root which need
+// to be updated when the named packages change, dependencies first. The root
+// package itself is last.
+func (ws *Workspace) TodoList(root *gx.Package, names []string) ([]string, error) {
+	var touched []string
+	// XXX This cache map might hide legitimate updates where
+	//     at the first pass we might not yet know we need to update the package.
+	memo := make(map[string]bool)
+
+	var checkRec func(pkg *gx.Package) (bool, error)
+	checkRec = func(pkg *gx.Package) (bool, error) {
+		var needsUpd bool
+		err := ws.forEachDep(pkg, func(dep *gx.Dependency, pkg *gx.Package) error {
+			for _, name := range names {
+				if dep.Name == name {
+					needsUpd = true
+					break
+				}
+			}
+			val, ok := memo[dep.Hash]
+			if ok {
+				needsUpd = val || needsUpd
+			} else {
+				nu, err := checkRec(pkg)
+				if err != nil {
+					return err
+				}
+
+				memo[dep.Hash] = nu
+				needsUpd = nu || needsUpd
+			}
+			return nil
+		})
+		if err != nil {
+			return false, err
+		}
+		if needsUpd {
+			touched = append(touched, pkg.Name)
+		}
+		return needsUpd, nil
+	}
+
+	needs, err := checkRec(root)
+	if err != nil {
+		return nil, err
+	}
+
+	if !needs {
+		return nil, fmt.Errorf("named package not in dependency tree")
+	}
+	return touched, nil
+}
+
+// EnumerateAllChildPackages returns the names of all packages in the
+// dependency tree of pkg.
+func (ws *Workspace) EnumerateAllChildPackages(pkg *gx.Package) ([]string, error) {
+	pkgs := make(map[string]*gx.Package)
+	if err := ws.collectChildPackages(pkg, pkgs); err != nil {
+		return nil, err
+	}
+
+	var aggr []string
+	for k := range pkgs {
+		aggr = append(aggr, k)
+	}
+
+	return aggr, nil
+}
+
+// PackageMatcher selects packages by name or dvcsimport. Globs are matched
+// against the package name, the dvcsimport path and every parent of the
+// dvcsimport path, so "github.com/multiformats" selects everything below it.
+type PackageMatcher struct {
+	Globs   []string
+	Regexps []*regexp.Regexp
+}
+
+// NewPackageMatcher returns a matcher for the given globs and regular
+// expressions, which are checked for syntax errors.
+func NewPackageMatcher(globs []string, regexps []string) (*PackageMatcher, error) {
+	m := &PackageMatcher{}
+	for _, g := range globs {
+		if _, err := path.Match(g, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %s", g, err)
+		}
+		m.Globs = append(m.Globs, g)
+	}
+	for _, r := range regexps {
+		re, err := regexp.Compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %q: %s", r, err)
+		}
+		m.Regexps = append(m.Regexps, re)
+	}
+	return m, nil
+}
+
+// Empty reports whether the matcher has no patterns at all.
+func (m *PackageMatcher) Empty() bool {
+	return len(m.Globs) == 0 && len(m.Regexps) == 0
+}
+
+// Match reports whether the package with the given name and dvcsimport is
+// selected by any glob or regular expression. Regular expressions are
+// matched against the name and the whole dvcsimport.
+func (m *PackageMatcher) Match(name string, dvcsimport string) bool {
+	candidates := []string{name}
+	if dvcsimport != "" {
+		parts := strings.Split(dvcsimport, "/")
+		for i := len(parts); i > 0; i-- {
+			candidates = append(candidates, strings.Join(parts[:i], "/"))
+		}
+	}
+
+	for _, g := range m.Globs {
+		for _, cand := range candidates {
+			if ok, _ := path.Match(g, cand); ok {
+				return true
+			}
+		}
+	}
+	for _, re := range m.Regexps {
+		if re.MatchString(name) || (dvcsimport != "" && re.MatchString(dvcsimport)) {
+			return true
+		}
+	}
+	return false
+}
+
+// SelectPackages returns names, followed by the packages in the dependency
+// tree of pkg selected by m which aren't named already. It fails if m has
+// patterns, but none of them matches.
+func (ws *Workspace) SelectPackages(pkg *gx.Package, names []string, m *PackageMatcher) ([]string, error) {
+	if m.Empty() {
+		return names, nil
+	}
+
+	matched, err := ws.MatchChildPackages(pkg, m)
+	if err != nil {
+		return nil, err
+	}
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("no packages in the dependency tree match the given patterns")
+	}
+	ws.printf("> Patterns matched %d packages: %s\n", len(matched), strings.Join(matched, ", "))
+
+	out := append([]string{}, names...)
+	for _, name := range matched {
+		if !containsString(out, name) {
+			out = append(out, name)
+		}
+	}
+	return out, nil
+}
+
+// MatchChildPackages returns the names of all packages in the dependency tree
+// of pkg accepted by the matcher.
+func (ws *Workspace) MatchChildPackages(pkg *gx.Package, m *PackageMatcher) ([]string, error) {
+	pkgs := make(map[string]*gx.Package)
+	if err := ws.collectChildPackages(pkg, pkgs); err != nil {
+		return nil, err
+	}
+
+	var out []string
+	for name, p := range pkgs {
+		if m.Match(name, pkgDvcsImport(p)) {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// collectChildPackages adds every package in the dependency tree of pkg to
+// pkgs, by name.
+func (ws *Workspace) collectChildPackages(pkg *gx.Package, pkgs map[string]*gx.Package) error {
+	return ws.forEachDep(pkg, func(dep *gx.Dependency, pkg *gx.Package) error {
+		// TODO: subtle bug here where we could miss some packages if they appear as
+		// dependencies in one version of a package, but not in another version with the
+		// same name. probably can worry about this later with a 'normalize' command
+		if _, ok := pkgs[dep.Name]; ok {
+			return nil
+		}
+		pkgs[dep.Name] = pkg
+
+		return ws.collectChildPackages(pkg, pkgs)
+	})
+}
+
+// LoadDepByName loads the named package from the dependency tree of pkg.
+func (ws *Workspace) LoadDepByName(pkg gx.Package, name string) (*gx.Package, error) {
+	if pkg.Name == name {
+		return &pkg, nil
+	}
+
+	deps, err := ws.PM.EnumerateDependencies(&pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range deps {
+		if v == name {
+			return ws.loadPackage(pkg.Language, k)
+		}
+	}
+	return nil, fmt.Errorf("dependency %s not found", name)
+}
+
+// PkgDirByName returns the directory in the GOPATH of the named package in
+// the dependency tree of root.
+func (ws *Workspace) PkgDirByName(root gx.Package, name string) (string, error) {
+	if name == root.Name {
+		return ws.PkgDir(&root)
+	}
+	dep, err := ws.LoadDepByName(root, name)
+	if err != nil {
+		return "", err
+	}
+	return ws.PkgDir(dep)
+}
+
+// GxDvcsImport returns the dvcsimport path of pkg.
+func GxDvcsImport(pkg *gx.Package) string {
+	pkggx := make(map[string]interface{})
+	_ = json.Unmarshal(pkg.Gx, &pkggx)
+	return pkggx["dvcsimport"].(string)
+}
+
+// pkgDvcsImport is like GxDvcsImport, but returns an empty string for
+// packages without a dvcsimport instead of panicking.
+func pkgDvcsImport(pkg *gx.Package) string {
+	imp, _ := gxMeta(pkg)["dvcsimport"].(string)
+	return imp
+}
+
+// gxMeta returns the "gx" section of the package file of pkg.
+func gxMeta(pkg *gx.Package) map[string]interface{} {
+	pkggx := make(map[string]interface{})
+	_ = json.Unmarshal(pkg.Gx, &pkggx)
+	return pkggx
+}
+
+// PkgDir returns the directory of the checkout of pkg in the GOPATH.
+func (ws *Workspace) PkgDir(pkg *gx.Package) (string, error) {
+	dir, err := ws.installPath(pkg.Language)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, GxDvcsImport(pkg)), nil
+}
+
+// installPath returns the directory gx installs packages of language lang
+// into, as printed by the install-path hook of gx-<lang>. Unlike
+// gx.InstallPath, the hook is run through the Runner, and its answer is
+// cached per GOPATH, which UseGoPath changes.
+func (ws *Workspace) installPath(lang string) (string, error) {
+	if lang == "" {
+		return "vendor", nil
+	}
+
+	key := lang + " " + os.Getenv("GOPATH")
+	ws.ipathLk.Lock()
+	defer ws.ipathLk.Unlock()
+	if p, ok := ws.ipaths[key]; ok {
+		return p, nil
+	}
+
+	out, err := ws.command("gx-"+lang, "hook", "install-path", "--global").Output()
+	if err != nil {
+		return "", fmt.Errorf("install-path hook failed: %s", err)
+	}
+	p := strings.TrimSpace(string(out))
+
+	if ws.ipaths == nil {
+		ws.ipaths = make(map[string]string)
+	}
+	ws.ipaths[key] = p
+	return p, nil
+}
+
+// loadPackage loads the installed package with the given hash.
+func (ws *Workspace) loadPackage(lang string, hash string) (*gx.Package, error) {
+	ipath, err := ws.installPath(lang)
+	if err != nil {
+		return nil, err
+	}
+
+	var pkg gx.Package
+	if err := gx.FindPackageInDir(&pkg, filepath.Join(ipath, "gx", "ipfs", hash)); err != nil {
+		return nil, err
+	}
+	return &pkg, nil
+}
+
+// forEachDep calls cb with every direct dependency of pkg, and the loaded
+// package of the dependency, like pkg.ForEachDep.
+func (ws *Workspace) forEachDep(pkg *gx.Package, cb func(dep *gx.Dependency, pkg *gx.Package) error) error {
+	for _, dep := range pkg.Dependencies {
+		cpkg, err := ws.loadPackage(pkg.Language, dep.Hash)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("package %s (%s) not found", dep.Name, dep.Hash)
+			}
+			return err
+		}
+
+		if err := cb(dep, cpkg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package workspace
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Hooks are shell commands run at fixed points of an update. They are
+// configured in gx-workspace.json, for all packages under "Hooks" and for
+// single packages under "Packages.<name>.Hooks", as a map from hook name to
+// a list of commands.
+const (
+	// HookPreUpdate runs in the checkout of a package before its
+	// dependencies are updated.
+	HookPreUpdate = "pre-update"
+	// HookPostInstall runs after the updated dependencies of a package have
+	// been installed.
+	HookPostInstall = "post-install"
+	// HookPreTest runs before a package is tested.
+	HookPreTest = "pre-test"
+	// HookPrePublish runs before a package is published, or committed if it
+	// is the root package.
+	HookPrePublish = "pre-publish"
+	// HookPostPublish runs after a package has been published.
+	HookPostPublish = "post-publish"
+	// HookPrePush runs before the branch of a package is pushed.
+	HookPrePush = "pre-push"
+)
+
+var hookNames = []string{
+	HookPreUpdate,
+	HookPostInstall,
+	HookPreTest,
+	HookPrePublish,
+	HookPostPublish,
+	HookPrePush,
+}
+
+func checkHooks(hooks map[string][]string) error {
+	for hook := range hooks {
+		var ok bool
+		for _, h := range hookNames {
+			if hook == h {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("unknown hook %q, must be one of %s", hook, strings.Join(hookNames, ", "))
+		}
+	}
+	return nil
+}
+
+// HookFailure records a hook which failed in the session state.
+type HookFailure struct {
+	Hook    string
+	Command string
+	Error   string
+}
+
+// HookError is returned when a hook fails. It halts the step it was run in.
+type HookError struct {
+	Package string
+	HookFailure
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("%s hook of %s failed: '%s': %s", e.Hook, e.Package, e.Command, e.HookFailure.Error)
+}
+
+// PostPublishError is returned by StepTwo when the post-publish hook of a
+// package fails. The package has been published regardless, so its step is
+// complete.
+type PostPublishError struct {
+	Package string
+	Err     error
+}
+
+func (e *PostPublishError) Error() string {
+	return e.Err.Error()
+}
+
+// runHook runs the commands of the named hook for a package, the workspace's
+// before the package's own, in the package directory. The commands see the
+// hook, the package and the workspace in GX_WORKSPACE_HOOK,
+// GX_WORKSPACE_PACKAGE and GX_WORKSPACE_DIR. The first failing command
+// stops the hook and is returned as a *HookError.
+func (ws *Workspace) runHook(w io.Writer, hook string, name string, dir string) error {
+	cmds := append([]string{}, ws.Config.Hooks[hook]...)
+	cmds = append(cmds, ws.Config.Packages[name].Hooks[hook]...)
+
+	for _, c := range cmds {
+		fmt.Fprintf(w, "> Running %s hook '%s'\n", hook, c)
+		cmd := ws.command("sh", "-c", c)
+		cmd.Dir = dir
+		cmd.Env = append(ws.Config.signingEnv(),
+			"GX_WORKSPACE_HOOK="+hook,
+			"GX_WORKSPACE_PACKAGE="+name,
+			"GX_WORKSPACE_DIR="+ws.Dir,
+		)
+		cmd.Stdout = w
+		cmd.Stderr = w
+		if err := cmd.Run(); err != nil {
+			return &HookError{
+				Package: name,
+				HookFailure: HookFailure{
+					Hook:    hook,
+					Command: c,
+					Error:   err.Error(),
+				},
+			}
+		}
+	}
+	return nil
+}
+
+// recordHookResult records a hook failure of the named package in the
+// session state, or clears the recorded failure once the package got past
+// the step in which it failed.
+func (ui *UpdateInfo) recordHookResult(name string, err error) {
+	if herr, ok := err.(*HookError); ok {
+		if ui.HookFailures == nil {
+			ui.HookFailures = map[string]HookFailure{}
+		}
+		ui.HookFailures[name] = herr.HookFailure
+	} else if err == nil {
+		delete(ui.HookFailures, name)
+	}
+}
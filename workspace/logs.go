@@ -0,0 +1,85 @@
+package workspace
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+const sessionDirName = ".gx-workspace"
+
+// SessionDir returns the directory holding the logs and other files of the
+// update.
+func (ws *Workspace) SessionDir(ui *UpdateInfo) string {
+	if ui.SessionDir != "" {
+		return ui.SessionDir
+	}
+	return filepath.Join(ws.Dir, sessionDirName, path.Base(ui.Branch))
+}
+
+func (ws *Workspace) logDir(ui *UpdateInfo, name string) string {
+	return filepath.Join(ws.SessionDir(ui), "logs", name)
+}
+
+// stepLog receives the output of all commands run for one step of one
+// package. With Verbose set, the output goes to Out as well.
+type stepLog struct {
+	io.Writer
+	Path string
+	fi   *os.File
+}
+
+func (ws *Workspace) openLog(ui *UpdateInfo, name string, step string) (*stepLog, error) {
+	dir := ws.logDir(ui, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	p := filepath.Join(dir, step+".log")
+	fi, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &stepLog{Writer: fi, Path: p, fi: fi}
+	if ws.Verbose {
+		l.Writer = io.MultiWriter(fi, ws.Out)
+	}
+	return l, nil
+}
+
+func (l *stepLog) Close() error {
+	return l.fi.Close()
+}
+
+// LogFiles returns the log files of the named package in the order the steps
+// were run. If step is not empty, only the log of that step is returned.
+func (ws *Workspace) LogFiles(ui *UpdateInfo, name string, step string) ([]string, error) {
+	dir := ws.logDir(ui, name)
+	pattern := "*.log"
+	if step != "" {
+		pattern = step + ".log"
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, pattern))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no logs for %s in %s", name, dir)
+	}
+
+	modtime := make(map[string]int64)
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil {
+			modtime[f] = fi.ModTime().UnixNano()
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return modtime[files[i]] < modtime[files[j]]
+	})
+	return files, nil
+}
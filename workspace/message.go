@@ -0,0 +1,121 @@
+package workspace
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+const defaultCommitTemplate = `gx: update {{join .Roots ", "}}`
+
+const defaultPullRequestTemplate = `gx: update {{join .Roots ", "}}
+{{- if .PullRequests}}
+
+Depends on:
+
+{{range .PullRequests}}- {{.}}
+{{end}}{{end}}
+
+This PR with gx updates has been created using gx-workspace: https://github.com/ipfs/gx-workspace`
+
+// DepUpdate describes the change of a single dependency in a package.
+type DepUpdate struct {
+	Name       string
+	OldVersion string
+	NewVersion string
+	OldHash    string
+	NewHash    string
+
+	// Changelog is the section of the dependency's CHANGELOG.md for the new
+	// version, if there is one.
+	Changelog string `json:",omitempty"`
+}
+
+// MessageData is passed to commit message and pull request templates.
+type MessageData struct {
+	// Package is the name of the package the message is for.
+	Package string
+	// Roots are the packages the update was started for.
+	Roots []string
+	// Updates are the dependencies changed in Package.
+	Updates []DepUpdate
+	// PullRequests are the pull requests opened so far in this update.
+	PullRequests []string
+}
+
+var messageFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+func renderMessage(tmpl string, data *MessageData) (string, error) {
+	t, err := template.New("message").Funcs(messageFuncs).Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	buf := new(bytes.Buffer)
+	if err := t.Execute(buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func commitMessage(conf *Config, data *MessageData) (string, error) {
+	tmpl, err := conf.template(conf.CommitTemplate, conf.CommitTemplateFile, defaultCommitTemplate)
+	if err != nil {
+		return "", err
+	}
+	return renderMessage(tmpl, data)
+}
+
+func pullRequestMessage(conf *Config, data *MessageData) (string, error) {
+	tmpl, err := conf.template(conf.PullRequestTemplate, conf.PullRequestTemplateFile, defaultPullRequestTemplate)
+	if err != nil {
+		return "", err
+	}
+	return renderMessage(tmpl, data)
+}
+
+// changelogSection returns the section of the CHANGELOG.md in the workspace
+// checkout of pkg whose heading mentions its version.
+func (ws *Workspace) changelogSection(pkg *gx.Package) string {
+	if pkgDvcsImport(pkg) == "" {
+		return ""
+	}
+	dir, err := ws.PkgDir(pkg)
+	if err != nil {
+		return ""
+	}
+
+	fi, err := os.Open(filepath.Join(dir, "CHANGELOG.md"))
+	if err != nil {
+		return ""
+	}
+	defer fi.Close()
+
+	var lines []string
+	level := 0
+	scan := bufio.NewScanner(fi)
+	for scan.Scan() {
+		line := scan.Text()
+		hashes := len(line) - len(strings.TrimLeft(line, "#"))
+
+		if level == 0 {
+			if hashes > 0 && strings.Contains(line, pkg.Version) {
+				level = hashes
+			}
+			continue
+		}
+		if hashes > 0 && hashes <= level {
+			break
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
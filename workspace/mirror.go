@@ -0,0 +1,154 @@
+package workspace
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// mirrorRoot returns the directory holding bare mirrors of repositories,
+// which clones are made from instead of the network.
+func mirrorRoot() (string, error) {
+	root, err := tempGoPathRoot()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, "mirrors"), nil
+}
+
+// mirrorDir returns the directory of the mirror of the repository with the
+// given dvcsimport, whether it exists or not.
+func mirrorDir(dvcsimport string) (string, error) {
+	root, err := mirrorRoot()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, dvcsimport+".git"), nil
+}
+
+// cloneFromMirror clones the mirror of dvcsimport into dir, if there is one,
+// and reports whether it did. Origin is then pointed at the repository's URL,
+// and the mirror is kept as the remote "mirror", which offline updates pull
+// from.
+func (ws *Workspace) cloneFromMirror(w io.Writer, dvcsimport string, dir string) (bool, error) {
+	mirror, err := mirrorDir(dvcsimport)
+	if err != nil {
+		return false, err
+	}
+	if _, err := os.Stat(mirror); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	if err := ws.vcs().Clone(w, mirror, dir); err != nil {
+		return true, err
+	}
+	if err := ws.runGit(w, dir, "remote", "set-url", "origin", ws.Config.cloneURL(dvcsimport)); err != nil {
+		return true, err
+	}
+	if err := ws.runGit(w, dir, "remote", "add", "mirror", mirror); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// pullRemote returns the remote to pull the repository at dir from: the
+// upstream remote, or its mirror when working offline.
+func (ws *Workspace) pullRemote(dir string) (string, error) {
+	if !ws.Config.Offline {
+		return ws.remotesAt(dir).Upstream, nil
+	}
+	remotes, err := ws.gitRemotes(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, r := range remotes {
+		if r == "mirror" {
+			return r, nil
+		}
+	}
+	return "", fmt.Errorf("can't update %s offline, it wasn't cloned from a mirror", dir)
+}
+
+// SyncMirrors creates or refreshes the mirrors of the named packages in the
+// dependency tree of the root package, or of all of them if names is empty.
+func (ws *Workspace) SyncMirrors(names []string) error {
+	root, err := ws.RootPackage()
+	if err != nil {
+		return err
+	}
+
+	deps, err := ws.PM.EnumerateDependencies(root)
+	if err != nil {
+		return err
+	}
+
+	want := map[string]bool{}
+	for _, name := range names {
+		want[name] = true
+	}
+
+	found := map[string]bool{}
+	synced := map[string]bool{}
+	for hash, name := range deps {
+		if len(names) > 0 && !want[name] {
+			continue
+		}
+		found[name] = true
+
+		dep, err := ws.loadPackage(root.Language, hash)
+		if err != nil {
+			return err
+		}
+		imp := pkgDvcsImport(dep)
+		if imp == "" {
+			ws.printf("WARNING: skipping %s, it has no dvcsimport\n", name)
+			continue
+		}
+		if synced[imp] {
+			continue
+		}
+		synced[imp] = true
+
+		if err := ws.syncMirror(imp); err != nil {
+			return fmt.Errorf("error syncing the mirror of %s: %s", name, err)
+		}
+	}
+
+	for _, name := range names {
+		if !found[name] {
+			return fmt.Errorf("dependency %s not found", name)
+		}
+	}
+	return nil
+}
+
+func (ws *Workspace) syncMirror(dvcsimport string) error {
+	dir, err := mirrorDir(dvcsimport)
+	if err != nil {
+		return err
+	}
+
+	var args []string
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+			return err
+		}
+		ws.printf("> Mirroring %s into %s\n", dvcsimport, dir)
+		args = []string{"clone", "--mirror", ws.Config.cloneURL(dvcsimport), dir}
+	} else {
+		ws.printf("> Updating the mirror of %s\n", dvcsimport)
+		args = []string{"--git-dir", dir, "remote", "update", "--prune"}
+	}
+
+	var out bytes.Buffer
+	cmd := ws.command("git", args...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error running 'git %s': %s\n%s", strings.Join(args, " "), err, out.String())
+	}
+	return nil
+}
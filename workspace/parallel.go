@@ -0,0 +1,227 @@
+package workspace
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// nextLevel returns the packages at the front of todo which don't depend on
+// any other package in todo, and can therefore be updated concurrently.
+// Because todo contains every package between the roots and the root
+// package, it's enough to look at direct dependencies.
+func (ws *Workspace) nextLevel(root gx.Package, todo []string) ([]string, error) {
+	intodo := make(map[string]bool)
+	for _, name := range todo {
+		intodo[name] = true
+	}
+
+	var level []string
+	for _, name := range todo {
+		pkg, err := ws.LoadDepByName(root, name)
+		if err != nil {
+			return nil, err
+		}
+
+		independent := true
+		for _, dep := range pkg.Dependencies {
+			if intodo[dep.Name] && dep.Name != name {
+				independent = false
+				break
+			}
+		}
+		if independent {
+			level = append(level, name)
+		}
+	}
+
+	if len(level) == 0 {
+		return todo[:1], nil
+	}
+	return level, nil
+}
+
+// PendingDirs returns the directories of the packages which have passed the
+// first step and are waiting to be published. While a level is being
+// published, ui.Current is one of them, so the whole level is returned.
+func (ws *Workspace) PendingDirs(ui *UpdateInfo) ([]string, error) {
+	if len(ui.Level) == 0 {
+		if ui.Current != "" {
+			return []string{ui.Current}, nil
+		}
+		return nil, nil
+	}
+
+	root, err := ws.RootPackage()
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	for _, name := range ui.Level {
+		dir, err := ws.PkgDirByName(*root, name)
+		if err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs, nil
+}
+
+type levelResult struct {
+	name string
+	log  string
+	step *stepOneResult
+	err  error
+}
+
+// levelStepOne runs the first step for all packages of the next
+// dependency level, using up to opts.Jobs concurrent workers. The output of each
+// package goes to its log, and a summary is printed once it has finished.
+// Packages which fail stay on the todo list.
+func (ws *Workspace) levelStepOne(ui *UpdateInfo, opts NextOptions) error {
+	if len(ui.Todo) == 0 {
+		return ws.StepOne(ui, opts)
+	}
+
+	pkg, err := ws.RootPackage()
+	if err != nil {
+		return err
+	}
+
+	level, err := ws.nextLevel(*pkg, ui.Todo)
+	if err != nil {
+		return err
+	}
+	if len(level) == 1 {
+		return ws.StepOne(ui, opts)
+	}
+
+	ws.printf("> Updating %d packages with %d jobs: %s\n", len(level), opts.Jobs, strings.Join(level, ", "))
+
+	// Every package of the level is checked before any of them is changed,
+	// so a refused checkout doesn't leave the others half updated. The
+	// preflight also writes to ui, which the workers must not race with.
+	logs := make([]*stepLog, len(level))
+	closeLogs := func() {
+		for _, log := range logs {
+			if log != nil {
+				log.Close()
+			}
+		}
+	}
+	for i, name := range level {
+		log, err := ws.openLog(ui, name, "update")
+		if err != nil {
+			closeLogs()
+			return err
+		}
+		logs[i] = log
+		if err := ws.preflightDep(log, ui, pkg, name, opts.Stash); err != nil {
+			closeLogs()
+			return err
+		}
+	}
+
+	var outlk sync.Mutex
+	results := make([]*levelResult, len(level))
+	sema := make(chan struct{}, opts.Jobs)
+	var wg sync.WaitGroup
+	for i, name := range level {
+		log := logs[i]
+		res := &levelResult{name: name, log: log.Path}
+		results[i] = res
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer log.Close()
+			sema <- struct{}{}
+			defer func() { <-sema }()
+
+			res.step, res.err = ws.stepOnePackage(log, *pkg, res.name, ui, opts)
+
+			outlk.Lock()
+			defer outlk.Unlock()
+			if _, ok := res.err.(*TestError); ok && opts.ContinueOnTestFailure {
+				ws.printf("!! Tests of %s failed, continuing anyway (log: %s)\n", res.name, res.log)
+			} else if res.err != nil {
+				ws.printf("!! Failed %s: %s (log: %s)\n", res.name, res.err, res.log)
+			} else {
+				ws.printf("> Finished %s, %d dependencies changed\n", res.name, len(res.step.updates))
+			}
+			if res.step != nil && len(res.step.findings) > 0 {
+				ws.printf("!! Checks of %s found %d problems (log: %s)\n", res.name, len(res.step.findings), res.log)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var failed []string
+	finished := make(map[string]bool)
+	for _, res := range results {
+		if res.step != nil {
+			ui.recordUpdates(res.name, res.step.updates)
+			ui.recordFindings(res.name, res.step.findings)
+		}
+		ui.recordHookResult(res.name, res.err)
+		res.err = ui.recordTestResult(res.name, res.err, opts.ContinueOnTestFailure)
+		if res.err != nil {
+			failed = append(failed, res.name)
+			continue
+		}
+		ui.recordStepOne(res.name, res.step)
+		ui.Level = append(ui.Level, res.name)
+		finished[res.name] = true
+	}
+
+	var todo []string
+	for _, name := range ui.Todo {
+		if !finished[name] {
+			todo = append(todo, name)
+		}
+	}
+	ui.Todo = todo
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to update %d packages: %s", len(failed), strings.Join(failed, ", "))
+	}
+
+	ws.printf("> Please verify before the changes get published and released.\n")
+	ws.printf("> Run `gx-workspace update next` to continue.\n")
+	return nil
+}
+
+// levelStepTwo publishes the packages of the current level one by one.
+func (ws *Workspace) levelStepTwo(ui *UpdateInfo) error {
+	pkg, err := ws.RootPackage()
+	if err != nil {
+		return err
+	}
+
+	for len(ui.Level) > 0 {
+		dir, err := ws.PkgDirByName(*pkg, ui.Level[0])
+		if err != nil {
+			return err
+		}
+
+		ui.Current = dir
+		err = ws.StepTwo(ui)
+		if _, ok := err.(*PostPublishError); err != nil && !ok {
+			return err
+		}
+		ui.Level = ui.Level[1:]
+
+		if werr := ws.WriteProgress(ui); werr != nil {
+			return werr
+		}
+		if err != nil {
+			// The package was published, only its hook has to be run
+			// by hand before continuing with the rest of the level.
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package workspace
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// AutoPublishAllowed decides whether the package at dir, which has passed
+// the first step, may be published without manual review. This is the case
+// if it was skipped, or if its tests ran and passed and its package.json only differs
+// from HEAD in the hashes and versions of its existing dependencies. If not,
+// the reason is returned.
+func (ws *Workspace) AutoPublishAllowed(ui *UpdateInfo, dir string) (bool, string, error) {
+	var current gx.Package
+	err := gx.LoadPackageFile(&current, filepath.Join(dir, gx.PkgFileName))
+	if err != nil {
+		return false, "", err
+	}
+
+	for _, name := range ui.Skipped {
+		if name == current.Name {
+			return true, "", nil
+		}
+	}
+
+	if msg, ok := ui.TestFailures[current.Name]; ok {
+		return false, "tests failed: " + msg, nil
+	}
+	if !ui.Tested[current.Name] {
+		return false, "its tests weren't run", nil
+	}
+
+	log, err := ws.openLog(ui, current.Name, "publish")
+	if err != nil {
+		return false, "", err
+	}
+	defer log.Close()
+
+	fmt.Fprintf(log, "> Running 'git show HEAD:%s' in %s\n", gx.PkgFileName, dir)
+	showcmd := ws.command("git", "show", "HEAD:"+gx.PkgFileName)
+	showcmd.Dir = dir
+	showcmd.Stderr = log
+	orig, err := showcmd.Output()
+	if err != nil {
+		return false, "", fmt.Errorf("error reading committed package.json: %s (log: %s)", err, log.Path)
+	}
+
+	updated, err := ioutil.ReadFile(filepath.Join(dir, gx.PkgFileName))
+	if err != nil {
+		return false, "", err
+	}
+
+	a, err := stripDependencyRefs(orig)
+	if err != nil {
+		return false, "", fmt.Errorf("error parsing committed package.json: %s", err)
+	}
+	b, err := stripDependencyRefs(updated)
+	if err != nil {
+		return false, "", fmt.Errorf("error parsing package.json: %s", err)
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		return false, "package.json changed beyond dependency hashes", nil
+	}
+	return true, "", nil
+}
+
+// stripDependencyRefs parses a package.json and blanks out the hash and
+// version of every dependency, so that two package files can be compared
+// without taking dependency updates into account.
+func stripDependencyRefs(data []byte) (map[string]interface{}, error) {
+	var pkg map[string]interface{}
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return nil, err
+	}
+
+	deps, _ := pkg["gxDependencies"].([]interface{})
+	for _, d := range deps {
+		dep, ok := d.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		delete(dep, "hash")
+		delete(dep, "version")
+	}
+	return pkg, nil
+}
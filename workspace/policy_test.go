@@ -0,0 +1,111 @@
+package workspace
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+func TestAutoPublishAllowed(t *testing.T) {
+	cases := []struct {
+		name string
+		opts NextOptions
+		// script changes the results of the runner before the first step,
+		// and change edits the package file of a afterwards.
+		script func(tt *testTree)
+		change func(t *testing.T, pkg *gx.Package)
+		// refused is the reason publishing a should be refused for, or
+		// empty if it's allowed.
+		refused string
+	}{
+		{
+			name: "tested",
+		},
+		{
+			name:    "not tested",
+			opts:    NextOptions{NoTest: true},
+			refused: "its tests weren't run",
+		},
+		{
+			name: "tolerated test failure",
+			opts: NextOptions{ContinueOnTestFailure: true},
+			script: func(tt *testTree) {
+				tt.runner.Results["gx test *"] = FakeResult{Err: fmt.Errorf("exit status 1")}
+			},
+			refused: "tests failed",
+		},
+		{
+			name: "package.json changed",
+			change: func(t *testing.T, pkg *gx.Package) {
+				pkg.License = "MIT"
+			},
+			refused: "package.json changed beyond dependency hashes",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tt := newTestTree(t)
+			ui := tt.start(t)
+			if c.script != nil {
+				c.script(tt)
+			}
+			if err := tt.ws.StepOne(ui, c.opts); err != nil {
+				t.Fatal(err)
+			}
+			if c.change != nil {
+				pfpath := filepath.Join(tt.dir("a"), gx.PkgFileName)
+				var pkg gx.Package
+				if err := gx.LoadPackageFile(&pkg, pfpath); err != nil {
+					t.Fatal(err)
+				}
+				c.change(t, &pkg)
+				if err := gx.SavePackageFile(&pkg, pfpath); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ok, reason, err := tt.ws.AutoPublishAllowed(ui, ui.Current)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.refused == "" {
+				if !ok {
+					t.Errorf("publishing a was refused: %s", reason)
+				}
+				return
+			}
+			if ok || !strings.Contains(reason, c.refused) {
+				t.Errorf("got allowed %v with reason %q, want refused because %q", ok, reason, c.refused)
+			}
+		})
+	}
+}
+
+func TestPendingDirsFailedLevel(t *testing.T) {
+	tt := newTestTree(t)
+	ui := tt.start(t)
+	if err := tt.ws.Next(ui, NextOptions{Jobs: 2, NoTest: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	tt.runner.Results["gx release *"] = FakeResult{Err: fmt.Errorf("exit status 1")}
+	if err := tt.ws.Next(ui, NextOptions{Jobs: 2, NoTest: true}); err == nil {
+		t.Fatal("publishing the level succeeded")
+	}
+	if ui.Current == "" {
+		t.Fatal("no package is current after the failed publish")
+	}
+
+	dirs, err := tt.ws.PendingDirs(ui)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{tt.dirOf(t, "a"), tt.dirOf(t, "b")}
+	if strings.Join(dirs, " ") != strings.Join(want, " ") {
+		t.Errorf("pending dirs are %v, want %v", dirs, want)
+	}
+}
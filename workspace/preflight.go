@@ -0,0 +1,213 @@
+package workspace
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// Stash records local changes stashed away before a package was updated.
+type Stash struct {
+	// Branch is the branch which was checked out.
+	Branch string
+	// Commit is the stash commit.
+	Commit string
+}
+
+// preflight checks that the existing checkout of the named package at dir
+// can be pulled and branched without losing anything: it must be on a
+// branch, without uncommitted changes, without commits missing upstream,
+// and without branches of other updates. With stash set, uncommitted changes
+// are stashed away instead, to be restored by RestoreStashes. Packages are
+// only checked once per update, before gx-workspace changes them, and not at
+// all when working in worktrees.
+func (ws *Workspace) preflight(w io.Writer, ui *UpdateInfo, name string, dir string, stash bool) error {
+	if ui.Preflighted[name] || ui.Worktrees {
+		return nil
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+
+	fmt.Fprintf(w, "> Checking the state of %s\n", dir)
+
+	var problems []string
+	var dirty bool
+
+	branch, err := ws.checkBranch(dir)
+	if err != nil {
+		return err
+	}
+	if branch == "HEAD" {
+		problems = append(problems, "HEAD is detached")
+		branch = ""
+	}
+
+	statuscmd := ws.command("git", "status", "--porcelain")
+	statuscmd.Dir = dir
+	out, err := statuscmd.Output()
+	if err != nil {
+		return fmt.Errorf("error checking git status: %s", err)
+	}
+	if len(strings.TrimSpace(string(out))) > 0 {
+		dirty = true
+		if !stash {
+			problems = append(problems, "uncommitted changes")
+		}
+	}
+
+	if branch != "" {
+		upstream := ws.remotesAt(dir).Upstream + "/" + ws.defaultBranch(w, dir, name)
+		countcmd := ws.command("git", "rev-list", "--count", upstream+".."+branch)
+		countcmd.Dir = dir
+		out, err = countcmd.Output()
+		if err != nil {
+			fmt.Fprintf(w, "WARNING: can't check for unpushed commits: %s\n", err)
+		} else if n := strings.TrimSpace(string(out)); n != "0" {
+			problems = append(problems, fmt.Sprintf("%s commits on %s which aren't on %s", n, branch, upstream))
+		}
+	}
+
+	branchcmd := ws.command("git", "branch", "--list", "--format=%(refname:short)", "gx/update-*")
+	branchcmd.Dir = dir
+	out, err = branchcmd.Output()
+	if err != nil {
+		return fmt.Errorf("error listing branches: %s", err)
+	}
+	for _, b := range strings.Fields(string(out)) {
+		if b != ui.Branch {
+			problems = append(problems, "branch "+b+" of another update exists")
+		}
+	}
+
+	if len(problems) > 0 {
+		msg := fmt.Sprintf("%s at %s: %s", name, dir, strings.Join(problems, ", "))
+		if dirty && !stash {
+			msg += " (pass --stash to stash uncommitted changes)"
+		}
+		return fmt.Errorf("refusing to update %s", msg)
+	}
+
+	if dirty {
+		if err := ws.stashChanges(w, ui, name, dir, branch); err != nil {
+			return err
+		}
+	}
+
+	if ui.Preflighted == nil {
+		ui.Preflighted = map[string]bool{}
+	}
+	ui.Preflighted[name] = true
+	return nil
+}
+
+// preflightDep runs the preflight for the named package in the dependency
+// tree of root. The root package itself is the user's checkout and isn't
+// checked.
+func (ws *Workspace) preflightDep(w io.Writer, ui *UpdateInfo, root *gx.Package, name string, stash bool) error {
+	if name == root.Name {
+		return nil
+	}
+	dir, err := ws.PkgDirByName(*root, name)
+	if err != nil {
+		return err
+	}
+	return ws.preflight(w, ui, name, dir, stash)
+}
+
+func (ws *Workspace) stashChanges(w io.Writer, ui *UpdateInfo, name string, dir string, branch string) error {
+	fmt.Fprintf(w, "> Running 'git stash push --include-untracked' in %s\n", dir)
+	stashcmd := ws.command("git", "stash", "push", "--include-untracked", "-m", "gx-workspace "+ui.Branch)
+	stashcmd.Dir = dir
+	stashcmd.Stdout = w
+	stashcmd.Stderr = w
+	if err := stashcmd.Run(); err != nil {
+		return fmt.Errorf("error stashing changes: %s", err)
+	}
+
+	revcmd := ws.command("git", "rev-parse", "stash@{0}")
+	revcmd.Dir = dir
+	out, err := revcmd.Output()
+	if err != nil {
+		return fmt.Errorf("error reading stash: %s", err)
+	}
+
+	if ui.Stashes == nil {
+		ui.Stashes = map[string]Stash{}
+	}
+	ui.Stashes[name] = Stash{Branch: branch, Commit: strings.TrimSpace(string(out))}
+	ws.printf("> Stashed local changes of %s\n", name)
+	return nil
+}
+
+// RestoreStashes checks out the original branch of every package whose
+// changes were stashed by the preflight, and applies the stash again.
+func (ws *Workspace) RestoreStashes(ui *UpdateInfo) error {
+	if err := ws.UseGoPath(ui); err != nil {
+		return err
+	}
+
+	root, err := ws.RootPackage()
+	if err != nil {
+		return err
+	}
+
+	for name, st := range ui.Stashes {
+		dir, err := ws.PkgDirByName(*root, name)
+		if err != nil {
+			return err
+		}
+
+		log, err := ws.openLog(ui, name, "restore")
+		if err != nil {
+			return err
+		}
+		err = ws.restoreStash(log, dir, st)
+		log.Close()
+		if err != nil {
+			return fmt.Errorf("error restoring the changes of %s: %s (log: %s)", name, err, log.Path)
+		}
+		ws.printf("> Restored local changes of %s on %s\n", name, st.Branch)
+
+		delete(ui.Stashes, name)
+		if err := ws.WriteProgress(ui); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ws *Workspace) restoreStash(w io.Writer, dir string, st Stash) error {
+	run := func(args ...string) error {
+		fmt.Fprintf(w, "> Running 'git %s' in %s\n", strings.Join(args, " "), dir)
+		cmd := ws.command("git", args...)
+		cmd.Dir = dir
+		cmd.Stdout = w
+		cmd.Stderr = w
+		return cmd.Run()
+	}
+
+	if err := run("checkout", st.Branch); err != nil {
+		return fmt.Errorf("error during git checkout: %s", err)
+	}
+	if err := run("stash", "apply", st.Commit); err != nil {
+		return fmt.Errorf("error applying stash: %s", err)
+	}
+
+	listcmd := ws.command("git", "stash", "list", "--format=%gd %H")
+	listcmd.Dir = dir
+	out, err := listcmd.Output()
+	if err != nil {
+		return fmt.Errorf("error listing stashes: %s", err)
+	}
+	for _, l := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(l)
+		if len(fields) == 2 && fields[1] == st.Commit {
+			return run("stash", "drop", fields[0])
+		}
+	}
+	return nil
+}
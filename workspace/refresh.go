@@ -0,0 +1,163 @@
+package workspace
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// Refresh rebases the update branch of every done package onto the freshly
+// fetched default branch. If the rebase conflicts, the branch is reset to the
+// default branch instead, and the dependency changes of the update are
+// applied to its package.json again. It returns the published packages whose
+// branch changed, which need to be published again.
+func (ws *Workspace) Refresh(ui *UpdateInfo) ([]string, error) {
+	if ui.Current != "" || len(ui.Level) > 0 {
+		return nil, fmt.Errorf("can't refresh in the middle of a step, run `gx-workspace update next` first")
+	}
+
+	if err := ws.UseGoPath(ui); err != nil {
+		return nil, err
+	}
+
+	root, err := ws.RootPackage()
+	if err != nil {
+		return nil, err
+	}
+
+	var republish []string
+	for _, name := range ui.Done {
+		dir, err := ws.PkgDirByName(*root, name)
+		if err != nil {
+			return nil, err
+		}
+
+		log, err := ws.openLog(ui, name, "refresh")
+		if err != nil {
+			return nil, err
+		}
+		moved, err := ws.refreshPackage(log, ui, name, dir)
+		log.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error refreshing %s: %s (log: %s)", name, err, log.Path)
+		}
+
+		if moved && name != root.Name {
+			republish = append(republish, name)
+		}
+		if moved && ui.PullRequests[name] != "" {
+			ws.printf("> The branch of %s's pull request must be force-pushed: %s\n", name, ui.PullRequests[name])
+		}
+	}
+	return republish, nil
+}
+
+// refreshPackage brings ui.Branch of the named package at dir up to date
+// with the default branch, and reports whether it had to be changed.
+func (ws *Workspace) refreshPackage(w io.Writer, ui *UpdateInfo, name string, dir string) (bool, error) {
+	git := func(args ...string) error {
+		fmt.Fprintf(w, "> Running 'git %s' in %s\n", strings.Join(args, " "), dir)
+		cmd := ws.command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = ws.Config.signingEnv()
+		cmd.Stdout = w
+		cmd.Stderr = w
+		return cmd.Run()
+	}
+
+	if _, err := ws.gitOutput(dir, "rev-parse", "--verify", "--quiet", ui.Branch); err != nil {
+		ws.printf("> Skipping %s, it has no branch %s\n", name, ui.Branch)
+		return false, nil
+	}
+
+	remote, err := ws.pullRemote(dir)
+	if err != nil {
+		return false, err
+	}
+	if err := git("fetch", remote); err != nil {
+		return false, fmt.Errorf("error during git fetch: %s", err)
+	}
+	base := remote + "/" + ws.defaultBranch(w, dir, name)
+
+	behind, err := ws.gitOutput(dir, "rev-list", "--count", ui.Branch+".."+base)
+	if err != nil {
+		return false, fmt.Errorf("error comparing with %s: %s", base, err)
+	}
+	if behind == "0" {
+		ws.printf("> %s is up to date with %s\n", name, base)
+		return false, nil
+	}
+
+	if err := git("checkout", ui.Branch); err != nil {
+		return false, fmt.Errorf("error during git checkout: %s", err)
+	}
+	if err := git("rebase", base); err == nil {
+		ws.printf("> Rebased %s onto %s\n", name, base)
+		return true, nil
+	}
+
+	if err := git("rebase", "--abort"); err != nil {
+		return false, fmt.Errorf("error aborting rebase: %s", err)
+	}
+	ws.printf("> Rebasing %s onto %s conflicts, applying its dependency changes again\n", name, base)
+
+	if err := git("checkout", "-B", ui.Branch, base); err != nil {
+		return false, fmt.Errorf("error during git checkout: %s", err)
+	}
+	if err := ws.reapplyUpdates(w, dir, ui.Updates[name]); err != nil {
+		return false, err
+	}
+
+	if err := git("add", gx.PkgFileName); err != nil {
+		return false, fmt.Errorf("error during git add: %s", err)
+	}
+	if err := git("diff", "--cached", "--quiet"); err == nil {
+		ws.printf("> %s already has all dependency changes on %s\n", name, base)
+		return true, nil
+	}
+	msg, err := commitMessage(ws.Config, &MessageData{
+		Package: name,
+		Roots:   ui.Roots,
+		Updates: ui.Updates[name],
+	})
+	if err != nil {
+		return false, fmt.Errorf("error rendering commit message: %s", err)
+	}
+	if err := git("commit", "-m", msg); err != nil {
+		return false, fmt.Errorf("error during git commit: %s", err)
+	}
+	return true, nil
+}
+
+// reapplyUpdates sets the dependencies of the package at dir to the new
+// versions in updates. Dependencies changed to something else since are left
+// alone.
+func (ws *Workspace) reapplyUpdates(w io.Writer, dir string, updates []DepUpdate) error {
+	pfpath := filepath.Join(dir, gx.PkgFileName)
+	var pkg gx.Package
+	if err := gx.LoadPackageFile(&pkg, pfpath); err != nil {
+		return err
+	}
+
+	for _, u := range updates {
+		for _, dep := range pkg.Dependencies {
+			if dep.Name != u.Name {
+				continue
+			}
+			switch dep.Hash {
+			case u.NewHash:
+			case u.OldHash:
+				dep.Hash = u.NewHash
+				dep.Version = u.NewVersion
+			default:
+				ws.printf("WARNING: %s was changed to %s upstream, not updating it to %s\n", dep.Name, dep.Hash, u.NewHash)
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "> Running SavePackageFile(%s) with updated dependencies.\n", pfpath)
+	return gx.SavePackageFile(&pkg, pfpath)
+}
@@ -0,0 +1,47 @@
+package workspace
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	billy "github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5/storage"
+)
+
+func TestRefreshConflict(t *testing.T) {
+	tt := newTestTree(t)
+	ui := finishUpdate(t, tt)
+
+	// All branches are behind, and rebasing a conflicts.
+	dir := tt.dirOf(t, "a")
+	tt.runner.Results["git rev-list --count "+ui.Branch+"..origin/master"] = FakeResult{Output: "1\n"}
+	tt.runner.Results["git rebase origin/master"] = FakeResult{Effect: func(c *Cmd) error {
+		if c.Dir == dir {
+			return fmt.Errorf("exit status 1")
+		}
+		return nil
+	}}
+	tt.runner.Results["git diff --cached --quiet"] = FakeResult{Err: fmt.Errorf("exit status 1")}
+
+	// The branch is recreated with git itself, even with the Go backend.
+	tt.ws.VCS = &GoGit{Storage: func(dir string) (storage.Storer, billy.Filesystem, error) {
+		return nil, nil, fmt.Errorf("no repository")
+	}}
+
+	republish, err := tt.ws.Refresh(ui)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(republish, " "); got != "a b" {
+		t.Errorf("republish is %q, want %q", got, "a b")
+	}
+	for _, line := range []string{"git checkout -B " + ui.Branch + " origin/master", "git add package.json", "git commit -m"} {
+		if !tt.ran(dir, line) {
+			t.Errorf("'%s' wasn't run in a:\n%s", line, tt.runner.Transcript())
+		}
+	}
+	if tt.ran(tt.dirOf(t, "b"), "git checkout -B "+ui.Branch+" origin/master") {
+		t.Errorf("b was recreated although it rebased cleanly")
+	}
+}
@@ -0,0 +1,180 @@
+package workspace
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// Cmd describes an invocation of an external command like git, gx, gx-go, go
+// or hub. It mirrors the parts of exec.Cmd used by gx-workspace, but is run
+// through a Runner.
+type Cmd struct {
+	Name string
+	Args []string
+	Dir  string
+	// Env holds variables added to the environment of the command.
+	Env    []string
+	Stdout io.Writer
+	Stderr io.Writer
+
+	runner Runner
+}
+
+// Run runs the command with the runner of the workspace which created it.
+func (c *Cmd) Run() error {
+	_, err := c.runner.Run(c)
+	return err
+}
+
+// Output runs the command with the runner of the workspace which created it,
+// and returns its standard output.
+func (c *Cmd) Output() ([]byte, error) {
+	c.Stdout = nil
+	return c.runner.Run(c)
+}
+
+func (c *Cmd) String() string {
+	return strings.Join(append([]string{c.Name}, c.Args...), " ")
+}
+
+// Runner runs external commands. If c.Stdout is nil, the standard output of
+// the command is returned instead of written.
+type Runner interface {
+	Run(c *Cmd) ([]byte, error)
+}
+
+// PackageManager is the part of *gx.PM used while updating packages.
+type PackageManager interface {
+	InstallPackage(hash, ipath string) (*gx.Package, error)
+	EnumerateDependencies(pkg *gx.Package) (map[string]string, error)
+}
+
+// ExecRunner runs commands using os/exec.
+type ExecRunner struct{}
+
+// Run runs c as a child process, with the environment of the current
+// process extended by c.Env. A command which isn't installed is reported as
+// required, so that e.g. git is only needed once something runs it.
+func (ExecRunner) Run(c *Cmd) ([]byte, error) {
+	cmd := exec.Command(c.Name, c.Args...)
+	cmd.Dir = c.Dir
+	cmd.Stdout = c.Stdout
+	cmd.Stderr = c.Stderr
+	if len(c.Env) > 0 {
+		cmd.Env = append(os.Environ(), c.Env...)
+	}
+
+	var out []byte
+	var err error
+	if c.Stdout == nil {
+		out, err = cmd.Output()
+	} else {
+		err = cmd.Run()
+	}
+	if errors.Is(err, exec.ErrNotFound) {
+		err = fmt.Errorf("%s is required, but wasn't found: %s", c.Name, err)
+	}
+	return out, err
+}
+
+// FakeResult is the scripted outcome of a command run by a FakeRunner.
+type FakeResult struct {
+	Output string
+	Err    error
+	// Effect, if set, is called before the result is returned, e.g. to
+	// create the files a real command would have created.
+	Effect func(c *Cmd) error
+	// Respond, if set, answers the command instead of Output and Err, e.g.
+	// differently depending on the directory it runs in.
+	Respond func(c *Cmd) (string, error)
+}
+
+// FakeRunner records the commands it's asked to run instead of running them,
+// and answers them from Results. Keys of Results are command lines as
+// returned by Cmd.String, or prefixes of them ending in "*". The longest
+// matching key wins; commands without a match get Default.
+type FakeRunner struct {
+	Results map[string]FakeResult
+	Default FakeResult
+
+	lk    sync.Mutex
+	calls []Cmd
+}
+
+// NewFakeRunner returns a FakeRunner without any results, which runs every
+// command successfully without output.
+func NewFakeRunner() *FakeRunner {
+	return &FakeRunner{Results: make(map[string]FakeResult)}
+}
+
+// Run records c and answers it with its scripted result. The Effect of the
+// result runs without holding any lock, so it may change Results.
+func (f *FakeRunner) Run(c *Cmd) ([]byte, error) {
+	f.lk.Lock()
+	f.calls = append(f.calls, *c)
+	res := f.lookup(c.String())
+	f.lk.Unlock()
+
+	if res.Effect != nil {
+		if err := res.Effect(c); err != nil {
+			return nil, err
+		}
+	}
+
+	if res.Respond != nil {
+		res.Output, res.Err = res.Respond(c)
+	}
+
+	if c.Stdout == nil {
+		return []byte(res.Output), res.Err
+	}
+	if _, err := io.WriteString(c.Stdout, res.Output); err != nil {
+		return nil, err
+	}
+	return nil, res.Err
+}
+
+func (f *FakeRunner) lookup(line string) FakeResult {
+	if res, ok := f.Results[line]; ok {
+		return res
+	}
+
+	best := -1
+	res := f.Default
+	for k, v := range f.Results {
+		if !strings.HasSuffix(k, "*") {
+			continue
+		}
+		prefix := strings.TrimSuffix(k, "*")
+		if strings.HasPrefix(line, prefix) && len(prefix) > best {
+			best = len(prefix)
+			res = v
+		}
+	}
+	return res
+}
+
+// Calls returns the commands run so far.
+func (f *FakeRunner) Calls() []Cmd {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	return append([]Cmd(nil), f.calls...)
+}
+
+// Transcript returns the commands run so far, one per line, prefixed with
+// the directory they were run in.
+func (f *FakeRunner) Transcript() string {
+	buf := new(bytes.Buffer)
+	for _, c := range f.Calls() {
+		fmt.Fprintf(buf, "%s: %s\n", c.Dir, c.String())
+	}
+	return buf.String()
+}
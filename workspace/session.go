@@ -0,0 +1,256 @@
+package workspace
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// UpdateInfo is the state of an update, as saved in gx-workspace-update.json.
+type UpdateInfo struct {
+	Roots        []string
+	Changes      map[string]string
+	Todo         []string
+	Current      string
+	Done         []string
+	Skipped      []string
+	GoPath       string
+	Branch       string
+	PullRequests map[string]string
+
+	// Release is the default release type passed to 'gx release', and
+	// Releases holds per-package overrides.
+	Release  string
+	Releases map[string]string
+
+	// Published holds the release type each package has been published
+	// with during the update.
+	Published map[string]string `json:",omitempty"`
+
+	// Updates records the dependencies changed in each package.
+	Updates map[string][]DepUpdate
+
+	// SessionDir holds logs and other files of this update.
+	SessionDir string
+
+	// Level holds the packages of a dependency level which were updated
+	// concurrently, and are waiting to be published.
+	Level []string
+
+	// HookFailures holds the last failed hook of each package, until the
+	// package gets past the step it failed in.
+	HookFailures map[string]HookFailure `json:",omitempty"`
+
+	// TestFailures holds the test errors of packages which were updated
+	// with failing tests, for later review.
+	TestFailures map[string]string `json:",omitempty"`
+
+	// Tested holds the packages whose tests passed in their last first
+	// step, so that untested changes aren't published automatically.
+	Tested map[string]bool `json:",omitempty"`
+
+	// Findings holds the problems found by the checks of each package.
+	Findings map[string][]Finding `json:",omitempty"`
+
+	// Preflighted holds the packages whose checkouts were found safe to
+	// update, and Stashes the local changes stashed away from them.
+	Preflighted map[string]bool  `json:",omitempty"`
+	Stashes     map[string]Stash `json:",omitempty"`
+
+	// Worktrees is set if packages are changed in worktrees under
+	// SessionDir instead of in their checkouts.
+	Worktrees bool `json:",omitempty"`
+
+	// PushRemotes holds the remote each package's branch was pushed to,
+	// which later pushes use again.
+	PushRemotes map[string]string `json:",omitempty"`
+}
+
+var releaseTypes = []string{"patch", "minor", "major"}
+
+// CheckReleaseType returns an error if rel isn't a release type understood by
+// 'gx release'.
+func CheckReleaseType(rel string) error {
+	for _, t := range releaseTypes {
+		if rel == t {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid release type %q, must be one of %s", rel, strings.Join(releaseTypes, ", "))
+}
+
+// ReleaseFor returns the release type to publish the named package with.
+func (ui *UpdateInfo) ReleaseFor(name string) string {
+	if rel, ok := ui.Releases[name]; ok {
+		return rel
+	}
+	if ui.Release != "" {
+		return ui.Release
+	}
+	return "patch"
+}
+
+// SetRelease sets the release type the named package will be published with.
+func (ui *UpdateInfo) SetRelease(name string, rel string) error {
+	if err := CheckReleaseType(rel); err != nil {
+		return err
+	}
+
+	if pub, ok := ui.Published[name]; ok {
+		return fmt.Errorf("%s has already been published as a %s release", name, pub)
+	}
+
+	if ui.Releases == nil {
+		ui.Releases = map[string]string{}
+	}
+	ui.Releases[name] = rel
+	return nil
+}
+
+// Finished reports whether all packages have been updated and published.
+func (ui *UpdateInfo) Finished() bool {
+	return ui.Current == "" && len(ui.Level) == 0 && len(ui.Todo) == 0
+}
+
+// recordUpdates adds the dependencies changed in an attempt of the first step
+// of the named package to those changed in earlier attempts. A dependency
+// changed again keeps its original old version.
+func (ui *UpdateInfo) recordUpdates(name string, updates []DepUpdate) {
+	if len(updates) == 0 {
+		return
+	}
+	if ui.Updates == nil {
+		ui.Updates = map[string][]DepUpdate{}
+	}
+
+	merged := ui.Updates[name]
+	for _, u := range updates {
+		found := false
+		for i, prev := range merged {
+			if prev.Name != u.Name {
+				continue
+			}
+			if prev.NewHash == u.OldHash {
+				u.OldVersion = prev.OldVersion
+				u.OldHash = prev.OldHash
+			}
+			merged[i] = u
+			found = true
+			break
+		}
+		if !found {
+			merged = append(merged, u)
+		}
+	}
+	ui.Updates[name] = merged
+}
+
+// recordStepOne marks the named package as done or skipped, depending on
+// whether any of its dependencies were changed in this or an earlier
+// attempt, and records whether it was tested.
+func (ui *UpdateInfo) recordStepOne(name string, res *stepOneResult) {
+	if res.tested {
+		if ui.Tested == nil {
+			ui.Tested = map[string]bool{}
+		}
+		ui.Tested[name] = true
+	} else {
+		delete(ui.Tested, name)
+	}
+
+	if len(ui.Updates[name]) > 0 {
+		ui.Done = append(ui.Done, name)
+	} else {
+		ui.Skipped = append(ui.Skipped, name)
+	}
+}
+
+// Undo puts the last 'done' package, or all packages of the current level,
+// back on the todo list. It returns false if there is nothing to undo.
+func (ui *UpdateInfo) Undo() bool {
+	if len(ui.Level) > 0 {
+		ui.undoLevel()
+		return true
+	}
+
+	if len(ui.Done) == 0 {
+		return false
+	}
+
+	name := ui.Done[len(ui.Done)-1]
+	ui.Todo = append([]string{name}, ui.Todo...)
+	ui.Done = ui.Done[:len(ui.Done)-1]
+	delete(ui.Published, name)
+	ui.Current = ""
+	return true
+}
+
+// undoLevel puts all packages of the current level back on the todo list.
+func (ui *UpdateInfo) undoLevel() {
+	inlevel := make(map[string]bool)
+	for _, name := range ui.Level {
+		inlevel[name] = true
+		delete(ui.Published, name)
+	}
+
+	remove := func(names []string) []string {
+		var out []string
+		for _, name := range names {
+			if !inlevel[name] {
+				out = append(out, name)
+			}
+		}
+		return out
+	}
+	ui.Done = remove(ui.Done)
+	ui.Skipped = remove(ui.Skipped)
+
+	ui.Todo = append(append([]string{}, ui.Level...), ui.Todo...)
+	ui.Level = nil
+	ui.Current = ""
+}
+
+// ProgressFile returns the path of the progress file of the workspace.
+func (ws *Workspace) ProgressFile() string {
+	return filepath.Join(ws.Dir, ProgressFile)
+}
+
+// ReadProgress reads the state of the update in progress.
+func (ws *Workspace) ReadProgress() (*UpdateInfo, error) {
+	return ReadProgressFile(ws.ProgressFile())
+}
+
+// ReadProgressFile reads the state of an update from the named file.
+func ReadProgressFile(fname string) (*UpdateInfo, error) {
+	fi, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+
+	var ui UpdateInfo
+	err = json.NewDecoder(fi).Decode(&ui)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ui, nil
+}
+
+// WriteProgress saves the state of the update in progress. The file is
+// replaced at once, so readers never see it partly written.
+func (ws *Workspace) WriteProgress(ui *UpdateInfo) error {
+	data, err := json.MarshalIndent(ui, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := ws.ProgressFile() + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ws.ProgressFile())
+}
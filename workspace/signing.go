@@ -0,0 +1,89 @@
+package workspace
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var signingFormats = []string{"openpgp", "x509", "ssh"}
+
+func checkSigningFormat(format string) error {
+	if format == "" {
+		return nil
+	}
+	for _, f := range signingFormats {
+		if format == f {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid SigningFormat %q, must be one of %s", format, strings.Join(signingFormats, ", "))
+}
+
+// signingEnv returns the environment which makes git sign commits and tags
+// as configured, or nothing if signing is off. The settings are passed as
+// GIT_CONFIG_* variables, so they also apply to the commits and tags made by
+// gx and hooks, and are added after any already in the environment.
+func (conf *Config) signingEnv() []string {
+	if !conf.Sign {
+		return nil
+	}
+
+	settings := [][2]string{
+		{"commit.gpgsign", "true"},
+		{"tag.gpgsign", "true"},
+	}
+	if conf.SigningKey != "" {
+		settings = append(settings, [2]string{"user.signingkey", conf.SigningKey})
+	}
+	if conf.SigningFormat != "" {
+		settings = append(settings, [2]string{"gpg.format", conf.SigningFormat})
+	}
+
+	n, _ := strconv.Atoi(os.Getenv("GIT_CONFIG_COUNT"))
+	var env []string
+	for _, s := range settings {
+		env = append(env,
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", n, s[0]),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", n, s[1]))
+		n++
+	}
+	return append(env, fmt.Sprintf("GIT_CONFIG_COUNT=%d", n))
+}
+
+// verifySignatures checks the signatures of the commits on branch which
+// aren't on base, and of the tags pointing at them.
+func (ws *Workspace) verifySignatures(w io.Writer, dir string, base string, branch string) error {
+	run := func(args ...string) error {
+		fmt.Fprintf(w, "> Running 'git %s' in %s\n", strings.Join(args, " "), dir)
+		cmd := ws.command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = ws.Config.signingEnv()
+		cmd.Stdout = w
+		cmd.Stderr = w
+		return cmd.Run()
+	}
+
+	out, err := ws.gitOutput(dir, "rev-list", base+".."+branch)
+	if err != nil {
+		return fmt.Errorf("error listing commits: %s", err)
+	}
+	for _, commit := range strings.Fields(out) {
+		if err := run("verify-commit", commit); err != nil {
+			return fmt.Errorf("commit %s has no valid signature", commit)
+		}
+
+		tags, err := ws.gitOutput(dir, "tag", "--points-at", commit)
+		if err != nil {
+			return fmt.Errorf("error listing tags: %s", err)
+		}
+		for _, tag := range strings.Fields(tags) {
+			if err := run("verify-tag", tag); err != nil {
+				return fmt.Errorf("tag %s has no valid signature", tag)
+			}
+		}
+	}
+	return nil
+}
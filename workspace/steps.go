@@ -0,0 +1,907 @@
+package workspace
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// StartOptions configure a new update.
+type StartOptions struct {
+	// Names are the packages to update throughout the tree.
+	Names []string
+	// Match and Regex add the packages in the dependency tree selected by
+	// these globs and regular expressions to Names, and All selects every
+	// package instead. They are expanded in the GOPATH of the update, once
+	// the dependencies of the root package are installed there.
+	Match []string
+	Regex []string
+	All   bool
+	// TempGoPath makes the update work in a new GOPATH under ~/.gx, instead
+	// of the current one. SeedGoPath optionally names a GOPATH to copy into
+	// it.
+	TempGoPath bool
+	SeedGoPath string
+	// SkipFailedClones skips named packages which can't be cloned, instead
+	// of failing.
+	SkipFailedClones bool
+	// Release is the release type packages are published with, "patch" if
+	// empty.
+	Release string
+	// Stash stashes uncommitted changes in existing checkouts instead of
+	// refusing to update them.
+	Stash bool
+	// Worktrees changes packages in git worktrees under the session
+	// directory, leaving existing checkouts alone.
+	Worktrees bool
+}
+
+// Start begins an update of the named packages throughout the dependency
+// tree. It syncs the repositories of the named packages, determines the
+// packages to change and saves the new update to the progress file.
+func (ws *Workspace) Start(opts StartOptions) (*UpdateInfo, error) {
+	pkg, err := ws.RootPackage()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(ws.ProgressFile()); err == nil {
+		return nil, fmt.Errorf("update already in progress")
+	}
+
+	release := opts.Release
+	if release == "" {
+		release = "patch"
+	}
+	if err := CheckReleaseType(release); err != nil {
+		return nil, err
+	}
+
+	m, err := NewPackageMatcher(opts.Match, opts.Regex)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.Names) == 0 && m.Empty() && !opts.All {
+		return nil, fmt.Errorf("must pass at least one package name or pattern")
+	}
+
+	var ui UpdateInfo
+
+	var gopath string
+
+	updatename := randomString(6)
+	if opts.TempGoPath {
+		root, err := tempGoPathRoot()
+		if err != nil {
+			return nil, err
+		}
+		gopath = filepath.Join(root, tempGoPathPrefix+updatename)
+
+		if err := ws.createTempGoPath(gopath, opts.SeedGoPath); err != nil {
+			return nil, err
+		}
+	} else {
+		if opts.SeedGoPath != "" {
+			return nil, fmt.Errorf("a seed GOPATH can only be used with a temporary GOPATH")
+		}
+		gopath = os.Getenv("GOPATH")
+	}
+	ui.GoPath = gopath
+	ui.Branch = "gx/update-" + updatename
+	ui.SessionDir = filepath.Join(ws.Dir, sessionDirName, "update-"+updatename)
+	ui.Release = release
+	ui.Releases = map[string]string{}
+	ui.Worktrees = opts.Worktrees
+
+	if err := ws.UseGoPath(&ui); err != nil {
+		return nil, err
+	}
+
+	log, err := ws.openLog(&ui, pkg.Name, "start")
+	if err != nil {
+		return nil, err
+	}
+	defer log.Close()
+
+	ws.printf("> Running 'gx install'\n")
+	gxinst := ws.command("gx", "install")
+	gxinst.Dir = ws.Dir
+	gxinst.Stdout = log
+	gxinst.Stderr = log
+	if err = gxinst.Run(); err != nil {
+		return nil, fmt.Errorf("error installing gx deps: %s (log: %s)", err, log.Path)
+	}
+
+	var names []string
+	if opts.All {
+		names, err = ws.EnumerateAllChildPackages(pkg)
+	} else {
+		names, err = ws.SelectPackages(pkg, opts.Names, m)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ui.Changes = map[string]string{}
+	ui.Done = []string{}
+	ui.Skipped = []string{}
+
+	for _, name := range names {
+		skip, err := ws.syncRepo(opts, *pkg, &ui, name)
+		if err != nil {
+			return nil, err
+		}
+		if !skip {
+			ui.Roots = append(ui.Roots, name)
+		}
+	}
+
+	touched, err := ws.TodoList(pkg, ui.Roots)
+	if err != nil {
+		return nil, fmt.Errorf("getTodoList failed: %s", err)
+	}
+	ui.Todo = touched
+
+	ws.printf("> Will change %d packages: %s\n", len(ui.Todo), strings.Join(ui.Todo, ", "))
+	ws.printf("> Run `gx-workspace update next` to continue.\n")
+
+	if err := ws.WriteProgress(&ui); err != nil {
+		return nil, err
+	}
+	return &ui, nil
+}
+
+func (ws *Workspace) syncRepo(opts StartOptions, parentpkg gx.Package, ui *UpdateInfo, name string) (bool, error) {
+	pkg, err := ws.LoadDepByName(parentpkg, name)
+	if err != nil {
+		return false, err
+	}
+	dir, err := ws.PkgDir(pkg)
+	if err != nil {
+		return false, err
+	}
+
+	log, err := ws.openLog(ui, name, "sync")
+	if err != nil {
+		return false, err
+	}
+	defer log.Close()
+
+	ws.printf("> Syncing %s (log: %s)\n", name, log.Path)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if ui.Worktrees {
+			err = ws.addWorktree(log, ui, name, GxDvcsImport(pkg), dir, false)
+		} else {
+			err = ws.gitClone(log, GxDvcsImport(pkg), dir)
+		}
+		if err != nil {
+			finalErr := fmt.Errorf("error cloning: %s", err)
+			if opts.SkipFailedClones {
+				ws.printf("WARNING: %s\n", finalErr)
+				return true, nil
+			} else {
+				return false, finalErr
+			}
+		}
+	} else {
+		if err := ws.preflight(log, ui, name, dir, opts.Stash); err != nil {
+			return false, err
+		}
+		if err := ws.gitPull(log, dir, ws.defaultBranch(log, dir, name)); err != nil {
+			return false, fmt.Errorf("error pulling latest: %s", err)
+		}
+	}
+
+	p := filepath.Join(dir, ".gx", "lastpubver")
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			ws.printf("WARNING: skipping non-gx package %q\n", name)
+			return true, nil
+		}
+		return false, err
+	}
+	pubver := strings.Fields(string(data))
+	if len(pubver) != 2 {
+		return false, fmt.Errorf("error parsing hash from %s", p)
+	}
+	ui.Changes[name] = pubver[1]
+
+	if changed, err := ws.hasChangesSincePublish(dir); err != nil {
+		fmt.Fprintf(log, "WARNING: can't check for unpublished changes: %s\n", err)
+	} else if changed {
+		ws.printf("WARNING: %s has commits which aren't published yet, the update will use its last published version %s\n", name, strings.TrimSuffix(pubver[0], ":"))
+	}
+
+	ipath, err := ws.installPath(pkg.Language)
+	if err != nil {
+		return false, err
+	}
+	_, err = ws.installPackage(log, ui.Changes[name], ipath)
+	if err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// NextOptions configure a step of an update.
+type NextOptions struct {
+	// NoTest skips running the tests of updated packages.
+	NoTest bool
+	// Jobs is the number of packages updated concurrently. With more than
+	// one job, all packages of the next dependency level are updated at
+	// once.
+	Jobs int
+	// CheckCmd, if set, replaces the check command of every package.
+	CheckCmd string
+	// ForceTest runs tests even if they passed before with the same code
+	// and dependencies.
+	ForceTest bool
+	// TestRetries, if set, replaces the configured number of times failing
+	// tests are run again.
+	TestRetries int
+	// ContinueOnTestFailure records packages whose tests fail in
+	// UpdateInfo.TestFailures instead of stopping the update.
+	ContinueOnTestFailure bool
+	// Strict fails the step if the checks for duplicate or missing
+	// dependencies find anything.
+	Strict bool
+	// Dedupe resolves duplicate dependencies of updated packages by
+	// updating the packages which pull in old versions first. It can't be
+	// combined with more than one job.
+	Dedupe bool
+	// Stash stashes uncommitted changes in existing checkouts instead of
+	// refusing to update them.
+	Stash bool
+}
+
+// Next executes the next step of the update, and saves the new state to the
+// progress file.
+func (ws *Workspace) Next(ui *UpdateInfo, opts NextOptions) error {
+	if err := ws.UseGoPath(ui); err != nil {
+		return err
+	}
+
+	if opts.Dedupe && opts.Jobs > 1 {
+		return fmt.Errorf("deduplicating can't be combined with more than one job")
+	}
+
+	var err error
+	switch {
+	case len(ui.Level) > 0:
+		err = ws.levelStepTwo(ui)
+	case ui.Current == "" && opts.Jobs > 1:
+		err = ws.levelStepOne(ui, opts)
+	case ui.Current == "":
+		err = ws.StepOne(ui, opts)
+	default:
+		err = ws.StepTwo(ui)
+	}
+
+	// The state is saved even if the step failed, to keep the packages
+	// which succeeded, and the hook failures and findings of those which
+	// didn't.
+	if werr := ws.WriteProgress(ui); werr != nil {
+		return werr
+	}
+	if err == nil && ui.Finished() {
+		ws.printSummary(ui)
+	}
+	return err
+}
+
+// StepOne updates the dependencies of the next package on the todo list,
+// and checks and tests it.
+func (ws *Workspace) StepOne(ui *UpdateInfo, opts NextOptions) error {
+	if len(ui.Todo) == 0 {
+		ws.printf("> We're done here.\n")
+		ws.printf("> You can now safely remove gx-workspace-update.json.\n")
+		return nil
+	}
+
+	ws.printf("updating package %s\n", ui.Todo[0])
+	pkg, err := ws.RootPackage()
+	if err != nil {
+		return err
+	}
+
+	log, err := ws.openLog(ui, ui.Todo[0], "update")
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+
+	if err := ws.preflightDep(log, ui, pkg, ui.Todo[0], opts.Stash); err != nil {
+		return err
+	}
+
+	res, err := ws.stepOnePackage(log, *pkg, ui.Todo[0], ui, opts)
+	if res != nil {
+		ui.recordUpdates(ui.Todo[0], res.updates)
+		ui.recordFindings(ui.Todo[0], res.findings)
+	}
+	ui.recordHookResult(ui.Todo[0], err)
+	if _, ok := err.(*TestError); ok && opts.ContinueOnTestFailure {
+		ws.printf("!! Tests of %s failed, continuing anyway (log: %s)\n", ui.Todo[0], log.Path)
+	}
+	err = ui.recordTestResult(ui.Todo[0], err, opts.ContinueOnTestFailure)
+	if err != nil {
+		return fmt.Errorf("%s (log: %s)", err, log.Path)
+	}
+
+	if len(res.dupes) > 0 {
+		name := ui.Todo[0]
+		ws.printf("> %s has duplicate dependencies: %s\n", name, strings.Join(res.dupes, ", "))
+		if _, err := ws.queueDedupe(ui, pkg, res.dupes); err != nil {
+			return err
+		}
+		if ui.Todo[0] == name {
+			return fmt.Errorf("can't resolve the duplicate dependencies of %s automatically", name)
+		}
+		ws.printf("> Will update %s first, todo: %s\n", ui.Todo[0], strings.Join(ui.Todo, ", "))
+		ws.printf("> Run `gx-workspace update next` to continue.\n")
+		return nil
+	}
+	ui.recordStepOne(ui.Todo[0], res)
+
+	if len(res.findings) > 0 {
+		ws.printf("!! Checks of %s found %d problems (log: %s)\n", ui.Todo[0], len(res.findings), log.Path)
+	}
+	if updates := ui.Updates[ui.Todo[0]]; len(updates) > 0 {
+		ws.printf("> Changed %d dependencies of %s at %s\n", len(updates), ui.Todo[0], res.dir)
+		ws.printf("> Please verify before the change gets published and released.\n")
+	} else {
+		ws.printf("> Going to skip %s, it doesn't need to be changed.\n", ui.Todo[0])
+	}
+	ws.printf("> Run `gx-workspace update next` to continue.\n")
+
+	ui.Todo = ui.Todo[1:]
+	ui.Current = res.dir
+	return nil
+}
+
+// stepOneResult is the outcome of the first step for one package.
+type stepOneResult struct {
+	dir      string
+	updates  []DepUpdate
+	findings []Finding
+	// tested is set if the tests passed, or passed before with the same
+	// code and dependencies.
+	tested bool
+	// dupes are duplicate dependencies found with NextOptions.Dedupe.
+	dupes []string
+}
+
+// stepOnePackage checks out the named package, updates its dependencies to
+// the hashes in changes, and runs the checks and tests if anything changed,
+// in this or an earlier attempt recorded in ui.Updates. The result is also
+// returned along with errors of the checks and tests, so that the updates
+// and findings can be recorded and failed tests can be tolerated.
+func (ws *Workspace) stepOnePackage(w io.Writer, pkg gx.Package, name string, ui *UpdateInfo, opts NextOptions) (*stepOneResult, error) {
+	changes := ui.Changes
+	var dir string
+	if name == pkg.Name {
+		var err error
+		dir, err = ws.PkgDir(&pkg)
+		if err != nil {
+			return nil, err
+		}
+		if ui.Worktrees {
+			if _, err := os.Stat(dir); os.IsNotExist(err) {
+				err := ws.addWorktree(w, ui, name, GxDvcsImport(&pkg), dir, true)
+				if err != nil {
+					return nil, err
+				}
+			}
+		} else {
+			err = os.MkdirAll(filepath.Dir(dir), 0755)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(w, "> Running Symlink(%s, %s)\n", ws.Dir, dir)
+			err = os.Symlink(ws.Dir, dir)
+			if err != nil {
+				fmt.Fprintln(w, err)
+			}
+		}
+	} else {
+		dep, err := ws.LoadDepByName(pkg, name)
+		if err != nil {
+			return nil, err
+		}
+
+		dir, err = ws.PkgDir(dep)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := os.Stat(dir); err != nil {
+			if !os.IsNotExist(err) {
+				return nil, err
+			}
+
+			if ui.Worktrees {
+				err = ws.addWorktree(w, ui, name, GxDvcsImport(dep), dir, false)
+			} else {
+				err = ws.gitClone(w, GxDvcsImport(dep), dir)
+				if err != nil {
+					err = fmt.Errorf("error cloning: %s", err)
+				}
+			}
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			if err := ws.gitPull(w, dir, ws.defaultBranch(w, dir, name)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := ws.runHook(w, HookPreUpdate, name, dir); err != nil {
+		return nil, err
+	}
+
+	// The updates are returned even if a later step fails, because they
+	// are in package.json from now on, and a retry won't find them again.
+	updates, err := ws.updatePackage(w, dir, changes)
+	res := &stepOneResult{dir: dir, updates: updates}
+	if err != nil {
+		return res, err
+	}
+
+	if len(updates) > 0 || len(ui.Updates[name]) > 0 {
+		if err := ws.runHook(w, HookPostInstall, name, dir); err != nil {
+			return res, err
+		}
+
+		if opts.Dedupe {
+			var updated gx.Package
+			err := gx.LoadPackageFile(&updated, filepath.Join(dir, gx.PkgFileName))
+			if err != nil {
+				return res, err
+			}
+			dupes, err := ws.Duplicates(&updated)
+			if err != nil {
+				return res, err
+			}
+			if len(dupes) > 0 {
+				// The dependencies pulling in old versions are updated
+				// first, checking this package now would be wasted.
+				res.dupes = duplicateNames(dupes)
+				return res, nil
+			}
+		}
+
+		res.findings, res.tested, err = ws.checkPackage(w, dir, opts)
+		if err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// StepTwo publishes the package at ui.Current, or commits it if it's the
+// root package.
+func (ws *Workspace) StepTwo(ui *UpdateInfo) error {
+	var current gx.Package
+	err := gx.LoadPackageFile(&current, filepath.Join(ui.Current, gx.PkgFileName))
+	if err != nil {
+		return err
+	}
+
+	changed := true
+	for _, name := range ui.Skipped {
+		if name == current.Name {
+			changed = false
+		}
+	}
+
+	root, err := ws.RootPackage()
+	if err != nil {
+		return err
+	}
+
+	log, err := ws.openLog(ui, current.Name, "publish")
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+
+	if changed {
+		err := ws.runHook(log, HookPrePublish, current.Name, ui.Current)
+		ui.recordHookResult(current.Name, err)
+		if err != nil {
+			return fmt.Errorf("%s (log: %s)", err, log.Path)
+		}
+	}
+
+	// A failed post-publish hook doesn't undo the publish, so the step is
+	// completed and the failure reported afterwards.
+	var hookErr error
+
+	// We don't want to publish the root package.
+	if changed && current.Name != root.Name {
+		rel := ui.ReleaseFor(current.Name)
+		name, hash, err := ws.publishAndRelease(log, ui.Current, ui.Branch, rel)
+		if err != nil {
+			return fmt.Errorf("%s (log: %s)", err, log.Path)
+		}
+		ui.Changes[name] = hash
+		if ui.Published == nil {
+			ui.Published = map[string]string{}
+		}
+		ui.Published[name] = rel
+		ws.printf("> Published package %s @ %s\n", ui.Current, hash)
+		ws.printf(">   For pinning: curl -X POST -F \"ghurl=%s\" http://mars.i.ipfs.team:9444/pin_package\n", GxDvcsImport(&current))
+
+		hookErr = ws.runHook(log, HookPostPublish, current.Name, ui.Current)
+		ui.recordHookResult(current.Name, hookErr)
+	} else if changed {
+		// The root package is the user's checkout, so the branch starts
+		// from whatever they have checked out.
+		err = ws.gitCheckout(log, ui.Current, ui.Branch, "")
+		if err != nil {
+			return fmt.Errorf("%s (log: %s)", err, log.Path)
+		}
+
+		fmt.Fprintf(log, "> Running 'git add package.json' in %s\n", ui.Current)
+		add := ws.command("git", "add", "package.json")
+		add.Dir = ui.Current
+		add.Stdout = log
+		add.Stderr = log
+		if err = add.Run(); err != nil {
+			return fmt.Errorf("error during git add: %s (log: %s)", err, log.Path)
+		}
+
+		msg, err := commitMessage(ws.Config, &MessageData{
+			Package: current.Name,
+			Roots:   ui.Roots,
+			Updates: ui.Updates[current.Name],
+		})
+		if err != nil {
+			return fmt.Errorf("error rendering commit message: %s", err)
+		}
+
+		fmt.Fprintf(log, "> Running 'git commit' in %s\n", ui.Current)
+		commitcmd := ws.command("git", "commit", "-m", msg)
+		commitcmd.Dir = ui.Current
+		commitcmd.Env = ws.Config.signingEnv()
+		commitcmd.Stdout = log
+		commitcmd.Stderr = log
+		if err = commitcmd.Run(); err != nil {
+			return fmt.Errorf("error during git commit: %s (log: %s)", err, log.Path)
+		}
+		ws.printf("> Committed %s on %s\n", current.Name, ui.Branch)
+	} else {
+		dir, err := ws.PkgDir(&current)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, ".gx", "lastpubver"))
+		if err != nil {
+			return err
+		}
+		ui.Changes[current.Name] = strings.Fields(string(data))[1]
+
+		ws.printf("> Skipping %s, it wasn't changed.\n", ui.Current)
+	}
+
+	done := len(ui.Done) + len(ui.Skipped)
+	total := done + len(ui.Todo)
+	if len(ui.Todo) > 0 {
+		ws.printf("> Progress: %d of %d packages, next: %s\n", done, total, ui.Todo[0])
+		ws.printf("> Run `gx-workspace update next` to continue.\n")
+	} else {
+		ws.printf("> Progress: %d of %d packages, finished.\n", done, total)
+		ws.printf("> You can now safely remove gx-workspace-update.json.\n")
+	}
+
+	ui.Current = ""
+	if hookErr != nil {
+		return &PostPublishError{
+			Package: current.Name,
+			Err:     fmt.Errorf("%s after publishing, run it by hand before continuing (log: %s)", hookErr, log.Path),
+		}
+	}
+	return nil
+}
+
+func (ws *Workspace) publishAndRelease(w io.Writer, dir string, branch string, release string) (string, string, error) {
+	fmt.Fprintf(w, "> Running 'gx-go uw'\n")
+	uwcmd := ws.command("gx-go", "uw")
+	uwcmd.Stdout = w
+	uwcmd.Stderr = w
+	uwcmd.Dir = dir
+	if err := uwcmd.Run(); err != nil {
+		return "", "", fmt.Errorf("error undoing dependency rewrite pre-publish: %s", err)
+	}
+
+	pfpath := filepath.Join(dir, gx.PkgFileName)
+	var pkg gx.Package
+	err := gx.LoadPackageFile(&pkg, pfpath)
+	if err != nil {
+		return "", "", err
+	}
+
+	if pkg.ReleaseCmd == "" {
+		return "", "", fmt.Errorf("%s at %s does not have releaseCmd set", pkg.Name, pfpath)
+	}
+
+	// The first step left the updated package.json uncommitted on top of
+	// the freshly pulled default branch, or on a worktree detached at it,
+	// so the branch starts from HEAD. A stale local default branch must not
+	// be used instead.
+	err = ws.gitCheckout(w, dir, branch, "")
+	if err != nil {
+		return "", "", fmt.Errorf("error during git checkout: %s", err)
+	}
+
+	fmt.Fprintf(w, "> Running 'gx release %s'\n", release)
+	cmd := ws.command("gx", "release", release)
+	cmd.Env = ws.Config.signingEnv()
+	cmd.Stdout = w
+	cmd.Stderr = w
+	cmd.Dir = dir
+	err = cmd.Run()
+	if err != nil {
+		return "", "", err
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, ".gx", "lastpubver"))
+	if err != nil {
+		return "", "", err
+	}
+
+	nhash := strings.Fields(string(data))[1]
+
+	ipath, err := ws.installPath(pkg.Language)
+	if err != nil {
+		return "", "", err
+	}
+
+	_, err = ws.installPackage(w, nhash, ipath)
+	if err != nil {
+		return "", "", err
+	}
+
+	return pkg.Name, nhash, nil
+}
+
+// updatePackage sets the dependencies of the package at dir to the hashes in
+// changes, and returns the dependencies it changed, also if installing them
+// fails after package.json has been saved.
+func (ws *Workspace) updatePackage(w io.Writer, dir string, changes map[string]string) ([]DepUpdate, error) {
+	fmt.Fprintf(w, "> Working in CWD=%s\n", dir)
+
+	pfpath := filepath.Join(dir, gx.PkgFileName)
+	var pkg gx.Package
+	err := gx.LoadPackageFile(&pkg, pfpath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ws.gxInstall(w, dir); err != nil {
+		return nil, err
+	}
+
+	ipath, err := ws.installPath(pkg.Language)
+	if err != nil {
+		return nil, err
+	}
+
+	var updates []DepUpdate
+	for _, dep := range pkg.Dependencies {
+		val, ok := changes[dep.Name]
+		if !ok || val == dep.Hash {
+			continue
+		}
+
+		chpkg, err := ws.installPackage(w, val, ipath)
+		if err != nil {
+			return nil, err
+		}
+
+		updates = append(updates, DepUpdate{
+			Name:       dep.Name,
+			OldVersion: dep.Version,
+			NewVersion: chpkg.Version,
+			OldHash:    dep.Hash,
+			NewHash:    val,
+			Changelog:  ws.changelogSection(chpkg),
+		})
+
+		dep.Version = chpkg.Version
+		dep.Hash = val
+	}
+
+	if len(updates) == 0 {
+		return nil, nil
+	}
+
+	fmt.Fprintf(w, "> Running SavePackageFile(%s) with updated dependencies.\n", pfpath)
+	err = gx.SavePackageFile(&pkg, pfpath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ws.gxInstall(w, dir); err != nil {
+		return updates, err
+	}
+
+	return updates, nil
+}
+
+// installPackage installs the package with the given hash into ipath.
+func (ws *Workspace) installPackage(w io.Writer, hash string, ipath string) (*gx.Package, error) {
+	ws.installLk.Lock()
+	defer ws.installLk.Unlock()
+
+	fmt.Fprintf(w, "> Running InstallPackage(%s)\n", hash)
+	return ws.PM.InstallPackage(hash, ipath)
+}
+
+// gxInstall runs 'gx install' in dir.
+func (ws *Workspace) gxInstall(w io.Writer, dir string) error {
+	ws.installLk.Lock()
+	defer ws.installLk.Unlock()
+
+	fmt.Fprintln(w, "> Running 'gx install'")
+	cmd := ws.command("gx", "install")
+	cmd.Dir = dir
+	cmd.Stdout = w
+	cmd.Stderr = w
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error installing gx deps: %s", err)
+	}
+	return nil
+}
+
+func (ws *Workspace) checkPackage(w io.Writer, dir string, opts NextOptions) ([]Finding, bool, error) {
+	fmt.Fprintln(w, "> Running 'gx deps dupes'")
+	dupecmd := ws.command("gx", "deps", "dupes")
+	dupecmd.Dir = dir
+	out, err := dupecmd.Output()
+	if err != nil {
+		return nil, false, fmt.Errorf("error checking dupes: %s", err)
+	}
+
+	findings := parseFindings(FindingDuplicate, out)
+	if len(findings) > 0 {
+		fmt.Fprintln(w, "!! Package has duplicate dependencies after updating: ")
+		w.Write(out)
+	}
+
+	missing, err := ws.checkForMissingDeps(w, dir)
+	if err != nil {
+		return findings, false, err
+	}
+	findings = append(findings, missing...)
+
+	if opts.Strict && len(findings) > 0 {
+		return findings, false, fmt.Errorf("checks found %d problems", len(findings))
+	}
+
+	pfpath := filepath.Join(dir, gx.PkgFileName)
+	var pkg gx.Package
+	err = gx.LoadPackageFile(&pkg, pfpath)
+	if err != nil {
+		return findings, false, err
+	}
+
+	if opts.NoTest {
+		fmt.Fprintln(w, "> Skipping gx tests")
+		return findings, false, nil
+	}
+
+	checkcmd := opts.CheckCmd
+	if checkcmd == "" {
+		checkcmd = ws.Config.checkCmd(&pkg)
+	}
+
+	skip := ws.Config.flakyTestsPattern(pkg.Name)
+	retries := opts.TestRetries
+	if retries == 0 {
+		retries = ws.Config.testRetries(pkg.Name)
+	}
+
+	entry, err := ws.testCacheEntryFor(dir, &pkg, checkcmd, skip)
+	if err != nil {
+		fmt.Fprintf(w, "WARNING: not caching test results: %s\n", err)
+		entry = nil
+	}
+	if entry != nil && !opts.ForceTest && testCached(entry) {
+		fmt.Fprintln(w, "> Skipping tests, they passed before with the same code and dependencies")
+		return findings, true, nil
+	}
+
+	if err := ws.runHook(w, HookPreTest, pkg.Name, dir); err != nil {
+		return findings, false, err
+	}
+
+	if err := ws.runTestsRetrying(w, dir, checkcmd, skip, retries); err != nil {
+		return findings, false, &TestError{Package: pkg.Name, Err: err}
+	}
+
+	if entry != nil {
+		if err := cacheTestPass(entry); err != nil {
+			fmt.Fprintf(w, "WARNING: error caching test results: %s\n", err)
+		}
+	}
+	return findings, true, nil
+}
+
+// runTests runs checkcmd in dir, or 'go get -d -t ./...' and 'gx test ./...'
+// if it's empty. Tests matching skip are excluded from 'gx test', and passed
+// to checkcmd in GX_WORKSPACE_SKIP_TESTS.
+func (ws *Workspace) runTests(w io.Writer, dir string, checkcmd string, skip string) error {
+	if checkcmd != "" {
+		fmt.Fprintf(w, "> Running '%s'\n", checkcmd)
+		check := ws.command("sh", "-c", checkcmd)
+		check.Dir = dir
+		if skip != "" {
+			check.Env = []string{"GX_WORKSPACE_SKIP_TESTS=" + skip}
+		}
+		check.Stdout = w
+		check.Stderr = w
+		if err := check.Run(); err != nil {
+			return fmt.Errorf("error running check command: %s", err)
+		}
+		return nil
+	}
+
+	fmt.Fprintln(w, "> Running 'go get -d -t ./...'")
+	gogetd := ws.command("go", "get", "-d", "-t", "./...")
+	gogetd.Dir = dir
+	gogetd.Stdout = w
+	gogetd.Stderr = w
+	if err := gogetd.Run(); err != nil {
+		return fmt.Errorf("error installing go deps: %s", err)
+	}
+	args := []string{"test"}
+	if skip != "" {
+		args = append(args, "-skip", skip)
+	}
+	args = append(args, "./...")
+	fmt.Fprintf(w, "> Running 'gx %s'\n", strings.Join(args, " "))
+	gxtest := ws.command("gx", args...)
+	gxtest.Dir = dir
+	gxtest.Stdout = w
+	gxtest.Stderr = w
+	if err := gxtest.Run(); err != nil {
+		return fmt.Errorf("error running tests: %s", err)
+	}
+	return nil
+}
+
+func (ws *Workspace) checkForMissingDeps(w io.Writer, dir string) ([]Finding, error) {
+	fmt.Fprintf(w, "> Running 'gx-go rw' in %s\n", dir)
+	rwcmd := ws.command("gx-go", "rw")
+	rwcmd.Dir = dir
+	rwcmd.Stdout = w
+	rwcmd.Stderr = w
+	if err := rwcmd.Run(); err != nil {
+		return nil, fmt.Errorf("error rewriting deps: %s", err)
+	}
+
+	fmt.Fprintf(w, "> Running 'gx-go dvcs-deps' in %s\n", dir)
+	ddcmd := ws.command("gx-go", "dvcs-deps")
+	ddcmd.Dir = dir
+	out, err := ddcmd.Output()
+	if err != nil {
+		w.Write(out)
+		return nil, fmt.Errorf("error while checking for missing deps: %s", err)
+	}
+
+	findings := parseFindings(FindingMissing, out)
+	if len(findings) > 0 {
+		fmt.Fprintln(w, "!! Package appears to have missing dependencies:")
+		w.Write(out)
+	}
+	return findings, nil
+}
@@ -0,0 +1,665 @@
+package workspace
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// testTree is a workspace whose root package app depends on a and b, which
+// both depend on c. The new version of c, QmC2, is published, and the
+// update of c throughout the tree is scripted with a FakeRunner and a
+// fakePM. Packages and checkouts live in a temporary GOPATH.
+type testTree struct {
+	ws     *Workspace
+	runner *FakeRunner
+	pm     *fakePM
+	src    string
+
+	// committed holds the package.json at HEAD of each checkout, which
+	// 'git show HEAD:package.json' answers with. Releases and commits
+	// update it.
+	lk        sync.Mutex
+	committed map[string][]byte
+}
+
+// fakePM installs packages by loading them from the gx directory of the
+// GOPATH, where the test has put them. Like gx, it doesn't allow concurrent
+// installs, and records them in overlapped.
+type fakePM struct {
+	src string
+
+	lk         sync.Mutex
+	installed  []string
+	installing bool
+	overlapped bool
+}
+
+func (pm *fakePM) InstallPackage(hash, ipath string) (*gx.Package, error) {
+	pm.lk.Lock()
+	pm.installed = append(pm.installed, hash)
+	pm.overlapped = pm.overlapped || pm.installing
+	pm.installing = true
+	pm.lk.Unlock()
+
+	// Give concurrent installs a chance to overlap.
+	time.Sleep(10 * time.Millisecond)
+	defer func() {
+		pm.lk.Lock()
+		pm.installing = false
+		pm.lk.Unlock()
+	}()
+
+	var pkg gx.Package
+	if err := gx.FindPackageInDir(&pkg, filepath.Join(ipath, "gx", "ipfs", hash)); err != nil {
+		return nil, err
+	}
+	return &pkg, nil
+}
+
+func (pm *fakePM) EnumerateDependencies(pkg *gx.Package) (map[string]string, error) {
+	deps := make(map[string]string)
+	var walk func(pkg *gx.Package) error
+	walk = func(pkg *gx.Package) error {
+		for _, dep := range pkg.Dependencies {
+			if _, ok := deps[dep.Hash]; ok {
+				continue
+			}
+			deps[dep.Hash] = dep.Name
+
+			var cpkg gx.Package
+			if err := gx.FindPackageInDir(&cpkg, filepath.Join(pm.src, "gx", "ipfs", dep.Hash)); err != nil {
+				return err
+			}
+			if err := walk(&cpkg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(pkg); err != nil {
+		return nil, err
+	}
+	return deps, nil
+}
+
+func (pm *fakePM) Installed() []string {
+	pm.lk.Lock()
+	defer pm.lk.Unlock()
+	return append([]string(nil), pm.installed...)
+}
+
+func testPackage(name string, version string, deps ...*gx.Dependency) *gx.Package {
+	pkg := &gx.Package{
+		PackageBase: gx.PackageBase{
+			Name:         name,
+			Version:      version,
+			Language:     "go",
+			Dependencies: deps,
+			ReleaseCmd:   "git commit -a -m \"gx publish $VERSION\"",
+		},
+		Gx: json.RawMessage(fmt.Sprintf(`{"dvcsimport":"github.com/test/%s"}`, name)),
+	}
+	return pkg
+}
+
+func writePackage(t *testing.T, dir string, pkg *gx.Package) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := gx.SavePackageFile(pkg, filepath.Join(dir, gx.PkgFileName)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeLastPubVer(t *testing.T, dir string, version string, hash string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Join(dir, ".gx"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	data := []byte(version + ": " + hash + "\n")
+	if err := ioutil.WriteFile(filepath.Join(dir, ".gx", "lastpubver"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestTree(t *testing.T) *testTree {
+	tmp := t.TempDir()
+	gopath := filepath.Join(tmp, "gopath")
+	src := filepath.Join(gopath, "src")
+	t.Setenv("GOPATH", gopath)
+	t.Setenv("GOBIN", filepath.Join(gopath, "bin"))
+	// The test cache lives in the home directory.
+	t.Setenv("HOME", tmp)
+	homedir.DisableCache = true
+
+	c1 := testPackage("c", "0.1.0")
+	c2 := testPackage("c", "0.2.0")
+	a := testPackage("a", "0.1.0", &gx.Dependency{Name: "c", Hash: "QmC1", Version: "0.1.0"})
+	b := testPackage("b", "0.1.0", &gx.Dependency{Name: "c", Hash: "QmC1", Version: "0.1.0"})
+	app := testPackage("app", "0.1.0",
+		&gx.Dependency{Name: "a", Hash: "QmA1", Version: "0.1.0"},
+		&gx.Dependency{Name: "b", Hash: "QmB1", Version: "0.1.0"})
+
+	for hash, pkg := range map[string]*gx.Package{"QmC1": c1, "QmC2": c2, "QmA1": a, "QmB1": b} {
+		writePackage(t, filepath.Join(src, "gx", "ipfs", hash, pkg.Name), pkg)
+	}
+	for hash, pkg := range map[string]*gx.Package{"QmC2": c2, "QmA1": a, "QmB1": b} {
+		dir := filepath.Join(src, "github.com", "test", pkg.Name)
+		writePackage(t, dir, pkg)
+		writeLastPubVer(t, dir, pkg.Version, hash)
+	}
+	root := filepath.Join(tmp, "app")
+	writePackage(t, root, app)
+
+	runner := NewFakeRunner()
+	pm := &fakePM{src: src}
+	tt := &testTree{runner: runner, pm: pm, src: src, committed: make(map[string][]byte)}
+	for _, dir := range []string{tt.dir("a"), tt.dir("b"), tt.dir("c"), root} {
+		if err := tt.commit(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	runner.Results["gx-go hook install-path --global"] = FakeResult{Output: src + "\n"}
+	runner.Results["git rev-parse --abbrev-ref HEAD"] = FakeResult{Output: "master\n"}
+	runner.Results["git rev-list --count *"] = FakeResult{Output: "0\n"}
+	runner.Results["git symbolic-ref --short refs/remotes/origin/HEAD"] = FakeResult{Output: "origin/master\n"}
+	runner.Results["git remote"] = FakeResult{Output: "origin\n"}
+	runner.Results["hub pull-request *"] = FakeResult{Output: "https://github.com/test/pulls/1\n"}
+	runner.Results["gx release *"] = FakeResult{Effect: func(c *Cmd) error {
+		if err := fakeRelease(src, c.Dir); err != nil {
+			return err
+		}
+		return tt.commit(c.Dir)
+	}}
+	runner.Results["git commit *"] = FakeResult{Effect: func(c *Cmd) error {
+		return tt.commit(c.Dir)
+	}}
+	runner.Results["git show HEAD:package.json"] = FakeResult{Respond: func(c *Cmd) (string, error) {
+		tt.lk.Lock()
+		defer tt.lk.Unlock()
+		data, ok := tt.committed[c.Dir]
+		if !ok {
+			return "", fmt.Errorf("exit status 128")
+		}
+		return string(data), nil
+	}}
+
+	ws := &Workspace{
+		Dir:    root,
+		PM:     pm,
+		Runner: runner,
+		Out:    ioutil.Discard,
+	}
+	conf, err := ws.loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.Config = conf
+
+	tt.ws = ws
+	return tt
+}
+
+// commit records the package.json in dir as committed at HEAD.
+func (tt *testTree) commit(dir string) error {
+	data, err := ioutil.ReadFile(filepath.Join(dir, gx.PkgFileName))
+	if err != nil {
+		return err
+	}
+
+	tt.lk.Lock()
+	defer tt.lk.Unlock()
+	tt.committed[dir] = data
+	return nil
+}
+
+// fakeRelease does what 'gx release' does to the package at dir: it bumps
+// the patch version and publishes the package as Qm<NAME>2.
+func fakeRelease(src string, dir string) error {
+	var pkg gx.Package
+	pfpath := filepath.Join(dir, gx.PkgFileName)
+	if err := gx.LoadPackageFile(&pkg, pfpath); err != nil {
+		return err
+	}
+	pkg.Version = "0.1.1"
+	hash := "Qm" + strings.ToUpper(pkg.Name) + "2"
+
+	if err := gx.SavePackageFile(&pkg, pfpath); err != nil {
+		return err
+	}
+	data := []byte(pkg.Version + ": " + hash + "\n")
+	if err := ioutil.WriteFile(filepath.Join(dir, ".gx", "lastpubver"), data, 0644); err != nil {
+		return err
+	}
+
+	pdir := filepath.Join(src, "gx", "ipfs", hash, pkg.Name)
+	if err := os.MkdirAll(pdir, 0755); err != nil {
+		return err
+	}
+	return gx.SavePackageFile(&pkg, filepath.Join(pdir, gx.PkgFileName))
+}
+
+func (tt *testTree) dir(name string) string {
+	if name == "app" {
+		return tt.ws.Dir
+	}
+	return filepath.Join(tt.src, "github.com", "test", name)
+}
+
+func (tt *testTree) start(t *testing.T) *UpdateInfo {
+	t.Helper()
+	ui, err := tt.ws.Start(StartOptions{Names: []string{"c"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ui
+}
+
+// ran reports whether a command starting with line was run in dir.
+func (tt *testTree) ran(dir string, line string) bool {
+	for _, c := range tt.runner.Calls() {
+		if c.Dir == dir && strings.HasPrefix(c.String(), line) {
+			return true
+		}
+	}
+	return false
+}
+
+func (tt *testTree) depHash(t *testing.T, name string, dep string) string {
+	t.Helper()
+	var pkg gx.Package
+	if err := gx.LoadPackageFile(&pkg, filepath.Join(tt.dir(name), gx.PkgFileName)); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range pkg.Dependencies {
+		if d.Name == dep {
+			return d.Hash
+		}
+	}
+	t.Fatalf("%s doesn't depend on %s", name, dep)
+	return ""
+}
+
+func TestStart(t *testing.T) {
+	tt := newTestTree(t)
+	ui := tt.start(t)
+
+	if got := strings.Join(ui.Todo, " "); got != "a b app" {
+		t.Errorf("todo is %q, want %q", got, "a b app")
+	}
+	if ui.Changes["c"] != "QmC2" {
+		t.Errorf("change of c is %q, want QmC2", ui.Changes["c"])
+	}
+	if !tt.ran(tt.dir("c"), "git pull origin master") {
+		t.Errorf("c wasn't pulled:\n%s", tt.runner.Transcript())
+	}
+	if _, err := os.Stat(tt.ws.ProgressFile()); err != nil {
+		t.Errorf("progress file wasn't written: %s", err)
+	}
+}
+
+func TestStepOne(t *testing.T) {
+	tt := newTestTree(t)
+	ui := tt.start(t)
+
+	if err := tt.ws.StepOne(ui, NextOptions{NoTest: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if ui.Current != tt.dir("a") {
+		t.Errorf("current is %q, want %q", ui.Current, tt.dir("a"))
+	}
+	if got := strings.Join(ui.Done, " "); got != "a" {
+		t.Errorf("done is %q, want a", got)
+	}
+	if got := tt.depHash(t, "a", "c"); got != "QmC2" {
+		t.Errorf("a depends on c at %s, want QmC2", got)
+	}
+	updates := ui.Updates["a"]
+	if len(updates) != 1 || updates[0].OldHash != "QmC1" || updates[0].NewVersion != "0.2.0" {
+		t.Errorf("updates of a are %+v", updates)
+	}
+	for _, line := range []string{"git pull origin master", "gx install", "gx deps dupes", "gx-go dvcs-deps"} {
+		if !tt.ran(tt.dir("a"), line) {
+			t.Errorf("'%s' wasn't run in a:\n%s", line, tt.runner.Transcript())
+		}
+	}
+	if tt.ran(tt.dir("a"), "gx release") {
+		t.Errorf("a was released in the first step")
+	}
+}
+
+func TestStepTwo(t *testing.T) {
+	tt := newTestTree(t)
+	ui := tt.start(t)
+
+	if err := tt.ws.StepOne(ui, NextOptions{NoTest: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tt.ws.StepTwo(ui); err != nil {
+		t.Fatal(err)
+	}
+
+	if ui.Current != "" {
+		t.Errorf("current is %q after publishing", ui.Current)
+	}
+	if ui.Changes["a"] != "QmA2" {
+		t.Errorf("change of a is %q, want QmA2", ui.Changes["a"])
+	}
+	if ui.Published["a"] != "patch" {
+		t.Errorf("a was published as %q, want patch", ui.Published["a"])
+	}
+	var checkout string
+	for _, c := range tt.runner.Calls() {
+		if c.Dir == tt.dir("a") && strings.HasPrefix(c.String(), "git checkout") {
+			checkout = c.String()
+		}
+	}
+	if checkout != "git checkout -B "+ui.Branch {
+		t.Errorf("checked out the branch with %q, want it based on HEAD", checkout)
+	}
+	if !tt.ran(tt.dir("a"), "gx release patch") {
+		t.Errorf("a wasn't released:\n%s", tt.runner.Transcript())
+	}
+
+	var installed bool
+	for _, hash := range tt.pm.Installed() {
+		installed = installed || hash == "QmA2"
+	}
+	if !installed {
+		t.Errorf("the release of a wasn't installed: %v", tt.pm.Installed())
+	}
+}
+
+func TestUndoTestCache(t *testing.T) {
+	tt := newTestTree(t)
+	ui := tt.start(t)
+
+	if err := tt.ws.Next(ui, NextOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !ui.Undo() {
+		t.Fatal("nothing to undo")
+	}
+	if err := tt.ws.Next(ui, NextOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if ui.Current != tt.dir("a") || !ui.Tested["a"] {
+		t.Fatalf("a wasn't updated and tested again: current %q, done %v, skipped %v", ui.Current, ui.Done, ui.Skipped)
+	}
+	var tests int
+	for _, c := range tt.runner.Calls() {
+		if c.Dir == tt.dir("a") && strings.HasPrefix(c.String(), "gx test") {
+			tests++
+		}
+	}
+	if tests != 1 {
+		t.Errorf("tests of a ran %d times, want once:\n%s", tests, tt.runner.Transcript())
+	}
+
+	if err := tt.ws.Next(ui, NextOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if ui.Changes["a"] != "QmA2" {
+		t.Errorf("change of a is %q, want QmA2", ui.Changes["a"])
+	}
+}
+
+func TestNextLevel(t *testing.T) {
+	tt := newTestTree(t)
+	ui := tt.start(t)
+	opts := NextOptions{NoTest: true, Jobs: 2}
+
+	if err := tt.ws.Next(ui, opts); err != nil {
+		t.Fatal(err)
+	}
+	tt.pm.lk.Lock()
+	if tt.pm.overlapped {
+		t.Errorf("packages were installed concurrently")
+	}
+	tt.pm.lk.Unlock()
+	if got := strings.Join(ui.Level, " "); got != "a b" {
+		t.Fatalf("level is %q, want %q", got, "a b")
+	}
+	if got := strings.Join(ui.Todo, " "); got != "app" {
+		t.Fatalf("todo is %q, want app", got)
+	}
+
+	if err := tt.ws.Next(ui, opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(ui.Level) != 0 || ui.Changes["a"] != "QmA2" || ui.Changes["b"] != "QmB2" {
+		t.Fatalf("level wasn't published: level %v, changes %v", ui.Level, ui.Changes)
+	}
+
+	// The root package is the only one left, it goes through the steps on
+	// its own and is committed instead of published.
+	for i := 0; i < 2; i++ {
+		if err := tt.ws.Next(ui, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !ui.Finished() {
+		t.Fatalf("update not finished: %+v", ui)
+	}
+	if got := tt.depHash(t, "app", "a"); got != "QmA2" {
+		t.Errorf("app depends on a at %s, want QmA2", got)
+	}
+	if got := tt.depHash(t, "app", "b"); got != "QmB2" {
+		t.Errorf("app depends on b at %s, want QmB2", got)
+	}
+	if !tt.ran(tt.dirOf(t, "app"), "git commit -m") {
+		t.Errorf("app wasn't committed:\n%s", tt.runner.Transcript())
+	}
+	if tt.ran(tt.dirOf(t, "app"), "gx release") {
+		t.Errorf("app was released")
+	}
+}
+
+// dirOf returns the directory the named package was changed in.
+func (tt *testTree) dirOf(t *testing.T, name string) string {
+	t.Helper()
+	root, err := tt.ws.RootPackage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := tt.ws.PkgDirByName(*root, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestNextLevelFailure(t *testing.T) {
+	tt := newTestTree(t)
+	ui := tt.start(t)
+	tt.runner.Results["gx-go dvcs-deps"] = FakeResult{Effect: func(c *Cmd) error {
+		if c.Dir == tt.dir("b") {
+			return fmt.Errorf("exit status 1")
+		}
+		return nil
+	}}
+
+	err := tt.ws.Next(ui, NextOptions{NoTest: true, Jobs: 2})
+	if err == nil || !strings.Contains(err.Error(), "b") {
+		t.Fatalf("expected b to fail, got %v", err)
+	}
+	if got := strings.Join(ui.Level, " "); got != "a" {
+		t.Errorf("level is %q, want a", got)
+	}
+	if got := strings.Join(ui.Todo, " "); got != "b app" {
+		t.Errorf("todo is %q, want %q", got, "b app")
+	}
+}
+
+func TestNextLevelPostPublishHook(t *testing.T) {
+	tt := newTestTree(t)
+	ui := tt.start(t)
+	opts := NextOptions{NoTest: true, Jobs: 2}
+	tt.ws.Config.Hooks = map[string][]string{HookPostPublish: {"announce"}}
+	tt.runner.Results["sh -c announce"] = FakeResult{Effect: func(c *Cmd) error {
+		if c.Dir == tt.dir("a") {
+			return fmt.Errorf("exit status 1")
+		}
+		return nil
+	}}
+
+	if err := tt.ws.Next(ui, opts); err != nil {
+		t.Fatal(err)
+	}
+	err := tt.ws.Next(ui, opts)
+	if _, ok := err.(*PostPublishError); !ok {
+		t.Fatalf("expected the hook of a to fail, got %v", err)
+	}
+	if got := strings.Join(ui.Level, " "); got != "b" {
+		t.Errorf("level is %q after publishing a, want b", got)
+	}
+
+	saved, err := tt.ws.ReadProgress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(saved.Level, " "); got != "b" {
+		t.Errorf("saved level is %q after publishing a, want b", got)
+	}
+
+	if err := tt.ws.Next(saved, opts); err != nil {
+		t.Fatal(err)
+	}
+	var releases int
+	for _, c := range tt.runner.Calls() {
+		if c.Dir == tt.dir("a") && strings.HasPrefix(c.String(), "gx release") {
+			releases++
+		}
+	}
+	if releases != 1 {
+		t.Errorf("a was released %d times, want once:\n%s", releases, tt.runner.Transcript())
+	}
+	if saved.Changes["b"] != "QmB2" {
+		t.Errorf("change of b is %q, want QmB2", saved.Changes["b"])
+	}
+}
+
+func TestNextLevelRetry(t *testing.T) {
+	tt := newTestTree(t)
+	ui := tt.start(t)
+	opts := NextOptions{NoTest: true, Jobs: 2}
+	tt.runner.Results["gx-go dvcs-deps"] = FakeResult{Effect: func(c *Cmd) error {
+		if c.Dir == tt.dir("a") {
+			return fmt.Errorf("exit status 1")
+		}
+		return nil
+	}}
+	if err := tt.ws.Next(ui, opts); err == nil {
+		t.Fatal("expected a to fail")
+	}
+
+	// package.json of a already has the new hash of c, but the retry must
+	// still publish a with the update.
+	delete(tt.runner.Results, "gx-go dvcs-deps")
+	for _, opts := range []NextOptions{opts, {NoTest: true}, {NoTest: true}} {
+		if err := tt.ws.Next(ui, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range ui.Skipped {
+		if name == "a" {
+			t.Errorf("a was skipped on the retry")
+		}
+	}
+	if ui.Changes["a"] != "QmA2" {
+		t.Errorf("change of a is %q, want QmA2", ui.Changes["a"])
+	}
+	updates := ui.Updates["a"]
+	if len(updates) != 1 || updates[0].OldHash != "QmC1" || updates[0].NewHash != "QmC2" {
+		t.Errorf("updates of a are %+v", updates)
+	}
+}
+
+func TestNextLevelPreflight(t *testing.T) {
+	tt := newTestTree(t)
+	ui := tt.start(t)
+	tt.runner.Results["git status --porcelain"] = FakeResult{Effect: func(c *Cmd) error {
+		if c.Dir == tt.dir("b") {
+			return fmt.Errorf("exit status 128")
+		}
+		return nil
+	}}
+
+	pulled := make(chan string, 2)
+	tt.runner.Results["git pull *"] = FakeResult{Effect: func(c *Cmd) error {
+		pulled <- c.Dir
+		return nil
+	}}
+
+	err := tt.ws.Next(ui, NextOptions{NoTest: true, Jobs: 2})
+	if err == nil || !strings.Contains(err.Error(), "git status") {
+		t.Fatalf("expected the preflight of b to fail, got %v", err)
+	}
+	// A worker started before the failure would pull its package soon.
+	select {
+	case dir := <-pulled:
+		t.Fatalf("%s was pulled although the preflight failed", dir)
+	case <-time.After(200 * time.Millisecond):
+	}
+	for _, name := range []string{"a", "b"} {
+		if tt.ran(tt.dir(name), "git pull") || tt.ran(tt.dir(name), "gx install") {
+			t.Errorf("%s was changed although the preflight failed:\n%s", name, tt.runner.Transcript())
+		}
+	}
+	if len(ui.Level) != 0 || strings.Join(ui.Todo, " ") != "a b app" {
+		t.Errorf("level %v and todo %v changed", ui.Level, ui.Todo)
+	}
+}
+
+func TestStartSelection(t *testing.T) {
+	cases := []struct {
+		name  string
+		opts  StartOptions
+		roots string
+		err   string
+	}{
+		{name: "names", opts: StartOptions{Names: []string{"c"}}, roots: "c"},
+		{name: "glob", opts: StartOptions{Match: []string{"github.com/test/c"}}, roots: "c"},
+		{name: "regex", opts: StartOptions{Names: []string{"b"}, Regex: []string{"^[bc]$"}}, roots: "b c"},
+		{name: "all", opts: StartOptions{All: true}, roots: "a b c"},
+		{name: "no match", opts: StartOptions{Match: []string{"nothing"}}, err: "no packages in the dependency tree match"},
+		{name: "nothing", err: "must pass at least one package name or pattern"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tt := newTestTree(t)
+			ui, err := tt.ws.Start(c.opts)
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("expected error %q, got %v", c.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			roots := append([]string(nil), ui.Roots...)
+			sort.Strings(roots)
+			if got := strings.Join(roots, " "); got != c.roots {
+				t.Errorf("roots are %q, want %q", got, c.roots)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package workspace
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// testCacheDir holds a file for every passing test run, named by the hash
+// of what was tested.
+const testCacheDir = "testcache"
+
+// testCacheEntry is stored for a passing test run. Failures aren't cached,
+// so they are always retried.
+type testCacheEntry struct {
+	Package string
+	Tree    string
+	Diff    string
+	Deps    []string
+	Check   string
+	Skip    string
+	Passed  time.Time
+}
+
+func (e *testCacheEntry) key() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "tree %s\n", e.Tree)
+	fmt.Fprintf(h, "diff %s\n", e.Diff)
+	for _, d := range e.Deps {
+		fmt.Fprintf(h, "dep %s\n", d)
+	}
+	fmt.Fprintf(h, "check %s\n", e.Check)
+	fmt.Fprintf(h, "skip %s\n", e.Skip)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func testCachePath(e *testCacheEntry) (string, error) {
+	root, err := tempGoPathRoot()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, testCacheDir, e.key()), nil
+}
+
+// testCacheEntryFor describes the tests of pkg at dir: the git tree of HEAD
+// and the uncommitted changes on top of it, the dependency hashes in the
+// package file, which already include the updates, the check command and the
+// skipped tests.
+func (ws *Workspace) testCacheEntryFor(dir string, pkg *gx.Package, checkcmd string, skip string) (*testCacheEntry, error) {
+	cmd := ws.command("git", "rev-parse", "HEAD^{tree}")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error reading git tree: %s", err)
+	}
+
+	diffcmd := ws.command("git", "diff", "HEAD")
+	diffcmd.Dir = dir
+	diff, err := diffcmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error reading git diff: %s", err)
+	}
+	diffsum := sha256.Sum256(diff)
+
+	e := &testCacheEntry{
+		Package: pkg.Name,
+		Tree:    strings.TrimSpace(string(out)),
+		Diff:    hex.EncodeToString(diffsum[:]),
+		Check:   checkcmd,
+		Skip:    skip,
+	}
+	for _, dep := range pkg.Dependencies {
+		e.Deps = append(e.Deps, dep.Name+" "+dep.Hash)
+	}
+	sort.Strings(e.Deps)
+	return e, nil
+}
+
+// testCached returns whether the tests described by e have passed before.
+func testCached(e *testCacheEntry) bool {
+	p, err := testCachePath(e)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(p)
+	return err == nil
+}
+
+// cacheTestPass records that the tests described by e have passed.
+func cacheTestPass(e *testCacheEntry) error {
+	p, err := testCachePath(e)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	e.Passed = time.Now()
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, data, 0644)
+}
@@ -0,0 +1,78 @@
+package workspace
+
+import (
+	"io"
+	"os"
+	"sort"
+)
+
+// UnpublishedPackage is a package of the dependency tree whose default
+// branch has commits which weren't released yet.
+type UnpublishedPackage struct {
+	Name string
+	Dir  string
+	// Commits are the unpublished commits as "<hash> <subject>", newest
+	// first.
+	Commits []string
+}
+
+// Unpublished lists the checked out packages in the dependency tree of the
+// root package whose default branch, as last fetched from upstream, has
+// commits after its last 'gx release'. With fetch set, upstream is fetched
+// first, with the output of git going to w. Packages which can't be checked
+// are skipped with a warning.
+func (ws *Workspace) Unpublished(w io.Writer, fetch bool) ([]UnpublishedPackage, error) {
+	root, err := ws.RootPackage()
+	if err != nil {
+		return nil, err
+	}
+
+	deps, err := ws.PM.EnumerateDependencies(root)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	var out []UnpublishedPackage
+	for hash, name := range deps {
+		dep, err := ws.loadPackage(root.Language, hash)
+		if err != nil {
+			return nil, err
+		}
+		if pkgDvcsImport(dep) == "" {
+			continue
+		}
+		dir, err := ws.PkgDir(dep)
+		if err != nil {
+			return nil, err
+		}
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			continue
+		}
+
+		upstream := ws.remotesAt(dir).Upstream
+		if fetch {
+			if err := ws.runGit(w, dir, "fetch", upstream); err != nil {
+				ws.printf("WARNING: can't fetch %s: %s\n", name, err)
+			}
+		}
+
+		rev := upstream + "/" + ws.defaultBranch(w, dir, name)
+		commits, err := ws.unpublishedCommits(dir, rev)
+		if err != nil {
+			ws.printf("WARNING: can't check %s: %s\n", name, err)
+			continue
+		}
+		if len(commits) > 0 {
+			out = append(out, UnpublishedPackage{Name: name, Dir: dir, Commits: commits})
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
@@ -0,0 +1,138 @@
+package workspace
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// VCS performs the git operations of an update. Unless Workspace.VCS is set,
+// they are done by running the git binary through the workspace's Runner.
+type VCS interface {
+	// Clone clones the repository at url into dir.
+	Clone(w io.Writer, url string, dir string) error
+	// Pull fetches branch from remote and merges it into the current
+	// branch of the repository at dir.
+	Pull(w io.Writer, dir string, remote string, branch string) error
+	// Checkout checks out branch, creating or resetting it at base, or at
+	// HEAD if base is empty. Local changes are kept.
+	Checkout(w io.Writer, dir string, branch string, base string) error
+	// CurrentBranch returns the checked out branch, or "HEAD" if HEAD is
+	// detached.
+	CurrentBranch(dir string) (string, error)
+	// DefaultBranch returns the branch HEAD of remote points to.
+	DefaultBranch(dir string, remote string) (string, error)
+	// Remotes returns the names of the remotes of the repository.
+	Remotes(dir string) ([]string, error)
+	// Push pushes branch to remote, and sets it as the upstream of branch.
+	Push(w io.Writer, dir string, remote string, branch string) error
+}
+
+// VCSError is returned by the VCS implementations when an operation fails.
+type VCSError struct {
+	// Op is the git operation, like "clone" or "push".
+	Op  string
+	Dir string
+	Err error
+}
+
+func (e *VCSError) Error() string {
+	return fmt.Sprintf("error during git %s in %s: %s", e.Op, e.Dir, e.Err)
+}
+
+func (ws *Workspace) vcs() VCS {
+	if ws.VCS != nil {
+		return ws.VCS
+	}
+	return &execGit{ws: ws}
+}
+
+// execGit runs the git binary.
+type execGit struct {
+	ws *Workspace
+}
+
+func (g *execGit) run(w io.Writer, dir string, op string, args ...string) error {
+	fmt.Fprintf(w, "> Running 'git %s' in %s\n", strings.Join(args, " "), dir)
+	cmd := g.ws.command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdout = w
+	cmd.Stderr = w
+	if err := cmd.Run(); err != nil {
+		return &VCSError{Op: op, Dir: dir, Err: err}
+	}
+	return nil
+}
+
+func (g *execGit) output(dir string, op string, args ...string) (string, error) {
+	cmd := g.ws.command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", &VCSError{Op: op, Dir: dir, Err: err}
+	}
+	return string(out), nil
+}
+
+func (g *execGit) Clone(w io.Writer, url string, dir string) error {
+	return g.run(w, filepath.Dir(dir), "clone", "clone", url, dir)
+}
+
+func (g *execGit) Pull(w io.Writer, dir string, remote string, branch string) error {
+	return g.run(w, dir, "pull", "pull", remote, branch)
+}
+
+func (g *execGit) Checkout(w io.Writer, dir string, branch string, base string) error {
+	args := []string{"checkout", "-B", branch}
+	if base != "" {
+		args = append(args, base)
+	}
+	return g.run(w, dir, "checkout", args...)
+}
+
+func (g *execGit) CurrentBranch(dir string) (string, error) {
+	out, err := g.output(dir, "rev-parse", "rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func (g *execGit) DefaultBranch(dir string, remote string) (string, error) {
+	out, err := g.output(dir, "symbolic-ref", "symbolic-ref", "--short", "refs/remotes/"+remote+"/HEAD")
+	if err == nil {
+		ref := strings.TrimSpace(out)
+		if strings.HasPrefix(ref, remote+"/") {
+			return strings.TrimPrefix(ref, remote+"/"), nil
+		}
+	}
+
+	out, err = g.output(dir, "remote show", "remote", "show", remote)
+	if err != nil {
+		return "", err
+	}
+	for _, l := range strings.Split(out, "\n") {
+		l = strings.TrimSpace(l)
+		if strings.HasPrefix(l, "HEAD branch:") {
+			b := strings.TrimSpace(strings.TrimPrefix(l, "HEAD branch:"))
+			if b != "" && b != "(unknown)" {
+				return b, nil
+			}
+		}
+	}
+	return "", &VCSError{Op: "remote show", Dir: dir, Err: fmt.Errorf("HEAD branch of %s unknown", remote)}
+}
+
+func (g *execGit) Remotes(dir string) ([]string, error) {
+	out, err := g.output(dir, "remote", "remote")
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Fields(out), nil
+}
+
+func (g *execGit) Push(w io.Writer, dir string, remote string, branch string) error {
+	return g.run(w, dir, "push", "push", "--set-upstream", remote, branch)
+}
@@ -0,0 +1,143 @@
+// Package workspace implements updating a package throughout a gx dependency
+// tree, as done by the gx-workspace tool.
+//
+// An update is started for one or more root packages with Workspace.Start,
+// which records the packages to be changed in an UpdateInfo, saved to
+// gx-workspace-update.json. Every call to Workspace.Next then advances the
+// update by one step: the first step of a package updates its dependencies
+// and runs its tests, the second one publishes it. Finally, Workspace.Push
+// pushes the changed packages and opens pull requests.
+//
+// All external commands are run through a Runner, and gx packages are
+// installed through a PackageManager, so both can be replaced.
+package workspace
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+// ProgressFile is the name of the file holding the state of an update, in
+// the directory of the root package.
+const ProgressFile = "gx-workspace-update.json"
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
+
+// Workspace is the root package of a dependency tree, and the settings used
+// to work on it.
+type Workspace struct {
+	// Dir is the directory of the root package.
+	Dir string
+	// Config holds the settings read from gx-workspace.json.
+	Config *Config
+
+	// PM installs gx packages and enumerates dependencies.
+	PM PackageManager
+	// Runner runs all external commands.
+	Runner Runner
+	// VCS performs clones, pulls, checkouts and pushes. If nil, the git
+	// binary is run through Runner.
+	VCS VCS
+
+	// Out receives progress messages.
+	Out io.Writer
+	// Verbose copies the output of all commands to Out, in addition to the
+	// logs.
+	Verbose bool
+
+	ipathLk sync.Mutex
+	ipaths  map[string]string
+
+	// installLk serializes installs, which all write to the gx directory
+	// of the GOPATH, also while a level is updated concurrently.
+	installLk sync.Mutex
+}
+
+// New returns the workspace of the root package in dir, using pm to install
+// packages. pm may be nil for read-only operations on the dependency tree.
+func New(dir string, pm PackageManager) (*Workspace, error) {
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ws := &Workspace{
+		Dir:    dir,
+		PM:     pm,
+		Runner: ExecRunner{},
+		Out:    os.Stdout,
+	}
+
+	conf, err := ws.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	ws.Config = conf
+
+	switch conf.GitBackend {
+	case "go":
+		ws.VCS = &GoGit{}
+	case "", "git":
+	default:
+		return nil, fmt.Errorf("unknown GitBackend %q in %s, must be git or go", conf.GitBackend, ConfigFile)
+	}
+
+	return ws, nil
+}
+
+// RootPackage loads the package file of the root package.
+func (ws *Workspace) RootPackage() (*gx.Package, error) {
+	var pkg gx.Package
+	err := gx.LoadPackageFile(&pkg, filepath.Join(ws.Dir, gx.PkgFileName))
+	if err != nil {
+		return nil, err
+	}
+	return &pkg, nil
+}
+
+// UseGoPath points GOPATH and GOBIN of the current process to the GOPATH of
+// the update, which is where gx installs and looks for packages. With
+// worktrees, the GOPATH holding them comes first.
+func (ws *Workspace) UseGoPath(ui *UpdateInfo) error {
+	gopath := ui.GoPath
+	if ui.Worktrees {
+		gopath = ws.worktreeGoPath(ui) + string(filepath.ListSeparator) + gopath
+	}
+	err := os.Setenv("GOPATH", gopath)
+	if err != nil {
+		return err
+	}
+	err = os.Setenv("GOBIN", filepath.Join(ui.GoPath, "bin"))
+	if err != nil {
+		return err
+	}
+	ws.printf("> Working in GOPATH=%s\n", gopath)
+	return nil
+}
+
+func (ws *Workspace) printf(format string, args ...interface{}) {
+	fmt.Fprintf(ws.Out, format, args...)
+}
+
+func (ws *Workspace) command(name string, args ...string) *Cmd {
+	return &Cmd{Name: name, Args: args, runner: ws.Runner}
+}
+
+const letterBytes = "abcdefghijklmnopqrstuvwxyz1234567890"
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = letterBytes[rand.Intn(len(letterBytes))]
+	}
+	return string(b)
+}
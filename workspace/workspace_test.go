@@ -0,0 +1,63 @@
+package workspace
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewGitBackend(t *testing.T) {
+	cases := []struct {
+		name    string
+		backend string
+		path    bool
+		gogit   bool
+		err     string
+	}{
+		{name: "default", path: true},
+		{name: "git", backend: "git", path: true},
+		{name: "go", backend: "go", path: true, gogit: true},
+		{name: "unknown", backend: "svn", path: true, err: "unknown GitBackend"},
+		{name: "no git"},
+		{name: "go without git", backend: "go", gogit: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if c.backend != "" {
+				conf := []byte(`{"GitBackend": "` + c.backend + `"}`)
+				if err := ioutil.WriteFile(filepath.Join(dir, ConfigFile), conf, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !c.path {
+				t.Setenv("PATH", dir)
+			}
+
+			ws, err := New(dir, nil)
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("expected error %q, got %v", c.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := ws.VCS.(*GoGit); ok != c.gogit {
+				t.Errorf("VCS is %T", ws.VCS)
+			}
+
+			// Without git, only the commands running it fail.
+			if !c.path {
+				cmd := ws.command("git", "status")
+				cmd.Dir = dir
+				if _, err := cmd.Output(); err == nil || !strings.Contains(err.Error(), "git is required") {
+					t.Errorf("expected git to be required, got %v", err)
+				}
+			}
+		})
+	}
+}
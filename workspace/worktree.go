@@ -0,0 +1,145 @@
+package workspace
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// worktreeGoPath returns the GOPATH holding the worktrees of an update
+// started with StartOptions.Worktrees. It comes first in GOPATH, so packages
+// are found and changed there instead of in the developers' checkouts.
+func (ws *Workspace) worktreeGoPath(ui *UpdateInfo) string {
+	return filepath.Join(ui.SessionDir, "gopath")
+}
+
+// repoDir returns the checkout which the worktree of a package with the given
+// dvcsimport is created from: the root package's directory, or the package's
+// directory in the GOPATH of the update.
+func (ws *Workspace) repoDir(ui *UpdateInfo, dvcsimport string, root bool) string {
+	if root {
+		return ws.Dir
+	}
+	gopath := filepath.SplitList(ui.GoPath)
+	if len(gopath) == 0 {
+		return ""
+	}
+	return filepath.Join(gopath[0], "src", dvcsimport)
+}
+
+// addWorktree creates a worktree of the named package at dir. It is detached
+// at the root package's HEAD, or at the freshly fetched default branch of
+// other packages, which are cloned first if there is no checkout yet.
+func (ws *Workspace) addWorktree(w io.Writer, ui *UpdateInfo, name string, dvcsimport string, dir string, root bool) error {
+	repo := ws.repoDir(ui, dvcsimport, root)
+	if repo == "" {
+		return fmt.Errorf("no GOPATH to find the repository of %s in", name)
+	}
+
+	base := "HEAD"
+	if !root {
+		if _, err := os.Stat(repo); os.IsNotExist(err) {
+			if err := ws.gitClone(w, dvcsimport, repo); err != nil {
+				return fmt.Errorf("error cloning: %s", err)
+			}
+		}
+		remote, err := ws.pullRemote(repo)
+		if err != nil {
+			return err
+		}
+		if err := ws.runGit(w, repo, "fetch", remote); err != nil {
+			return fmt.Errorf("error during git fetch: %s", err)
+		}
+		base = remote + "/" + ws.defaultBranch(w, repo, name)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	if err := ws.runGit(w, repo, "worktree", "add", "--detach", dir, base); err != nil {
+		return fmt.Errorf("error adding worktree: %s", err)
+	}
+	return nil
+}
+
+// RemoveWorktrees removes the worktrees of an update from their repositories.
+// The branches created in them are kept.
+func (ws *Workspace) RemoveWorktrees(ui *UpdateInfo) error {
+	src := filepath.Join(ws.worktreeGoPath(ui), "src")
+	var dirs []string
+	err := filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !fi.IsDir() || p == src {
+			return nil
+		}
+		// gx installs packages of the update into the worktree GOPATH as
+		// well, but never as worktrees.
+		if p == filepath.Join(src, "gx") {
+			return filepath.SkipDir
+		}
+		// The .git of a worktree is a file pointing into its repository.
+		if gfi, err := os.Lstat(filepath.Join(p, ".git")); err == nil {
+			if !gfi.IsDir() {
+				dirs = append(dirs, p)
+			}
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range dirs {
+		commondir, err := ws.gitOutput(dir, "rev-parse", "--path-format=absolute", "--git-common-dir")
+		if err != nil {
+			return fmt.Errorf("error finding the repository of worktree %s: %s", dir, err)
+		}
+		repo := filepath.Dir(commondir)
+		if err := ws.runGit(ws.Out, repo, "worktree", "remove", "--force", dir); err != nil {
+			return fmt.Errorf("error removing worktree %s: %s", dir, err)
+		}
+	}
+	return nil
+}
+
+// Abort gives up the update: its worktrees are removed, and so is the
+// progress file, so a new update can be started. Branches and published
+// packages are left alone. Stashed changes must be restored first.
+func (ws *Workspace) Abort(ui *UpdateInfo) error {
+	if len(ui.Stashes) > 0 {
+		return fmt.Errorf("changes of %d packages are still stashed, run `gx-workspace update restore` first", len(ui.Stashes))
+	}
+	if ui.Worktrees {
+		if err := ws.RemoveWorktrees(ui); err != nil {
+			return err
+		}
+	}
+	return os.Remove(ws.ProgressFile())
+}
+
+func (ws *Workspace) runGit(w io.Writer, dir string, args ...string) error {
+	fmt.Fprintf(w, "> Running 'git %s' in %s\n", strings.Join(args, " "), dir)
+	cmd := ws.command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdout = w
+	cmd.Stderr = w
+	return cmd.Run()
+}
+
+func (ws *Workspace) gitOutput(dir string, args ...string) (string, error) {
+	cmd := ws.command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}